@@ -0,0 +1,273 @@
+package basics
+
+import (
+	"errors"
+	"github.com/olivere/elastic"
+	"strconv"
+	"strings"
+)
+
+// reverseNestedName nested中的分桶聚合自动添加的 reverse_nested 子聚合名称, 用于统计每个桶对应的根文档数
+const reverseNestedName = "reverse_nested"
+
+// aggRange range聚合的一个区间, 为空表示不限制
+type aggRange struct {
+	From *float64
+	To   *float64
+}
+
+// parseAggRanges 解析 ranges tag, 区间使用英文逗号分割, 区间上下限使用~分割, 如: ~100,100~200,200~
+func parseAggRanges(s string) (res []aggRange, err error) {
+	for _, v := range strings.Split(s, ",") {
+		ft := strings.Split(v, "~")
+		if len(ft) != 2 {
+			return nil, errors.New("ranges格式错误, 如: ~100,100~200,200~")
+		}
+		var r aggRange
+		if r.From, err = parseAggBound(ft[0]); err != nil {
+			return
+		}
+		if r.To, err = parseAggBound(ft[1]); err != nil {
+			return
+		}
+		if r.From == nil && r.To == nil {
+			return nil, errors.New("ranges区间的上下限不能都为空")
+		}
+		res = append(res, r)
+	}
+	return
+}
+
+func parseAggBound(s string) (*float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, errors.New("ranges值只能是数字")
+	}
+	return &f, nil
+}
+
+// checkAgg 检查聚合相关的tag
+func checkAgg(tags *esTags) error {
+	switch tags.Agg {
+	case "", "terms", "cardinality", "min", "max", "avg", "sum", "stats":
+	case "histogram":
+		if _, err := strconv.ParseFloat(tags.Interval, 64); err != nil {
+			return errors.New("histogram 需要数字类型的interval")
+		}
+	case "date_histogram":
+		if tags.Interval == "" {
+			return errors.New("date_histogram 需要指定interval")
+		}
+	case "range":
+		if len(tags.Ranges) == 0 {
+			return errors.New("range 需要指定ranges")
+		}
+	default:
+		return errors.New("agg: " + tags.Agg + " 不存在")
+	}
+	return nil
+}
+
+// newAggregation 根据tag创建聚合, bucket 表示是否为分桶聚合
+func newAggregation(tags *esTags, field string) (agg elastic.Aggregation, bucket bool) {
+	switch tags.Agg {
+	case "terms":
+		a := elastic.NewTermsAggregation().Field(field)
+		if tags.Size > 0 {
+			a.Size(tags.Size)
+		}
+		if tags.MinDocCount != nil {
+			a.MinDocCount(int(*tags.MinDocCount))
+		}
+		return a, true
+	case "cardinality":
+		return elastic.NewCardinalityAggregation().Field(field), false
+	case "min":
+		return elastic.NewMinAggregation().Field(field), false
+	case "max":
+		return elastic.NewMaxAggregation().Field(field), false
+	case "avg":
+		return elastic.NewAvgAggregation().Field(field), false
+	case "sum":
+		return elastic.NewSumAggregation().Field(field), false
+	case "stats":
+		return elastic.NewStatsAggregation().Field(field), false
+	case "date_histogram":
+		a := elastic.NewDateHistogramAggregation().Field(field).Interval(tags.Interval)
+		if tags.Format != "" {
+			a.Format(tags.Format)
+		}
+		if tags.TimeZone != "" {
+			a.TimeZone(tags.TimeZone)
+		}
+		if tags.MinDocCount != nil {
+			a.MinDocCount(*tags.MinDocCount)
+		}
+		return a, true
+	case "histogram":
+		interval, _ := strconv.ParseFloat(tags.Interval, 64)
+		a := elastic.NewHistogramAggregation().Field(field).Interval(interval)
+		if tags.MinDocCount != nil {
+			a.MinDocCount(*tags.MinDocCount)
+		}
+		return a, true
+	case "range":
+		a := elastic.NewRangeAggregation().Field(field)
+		for _, r := range tags.Ranges {
+			if r.From == nil {
+				a.AddUnboundedFrom(*r.To)
+			} else if r.To == nil {
+				a.AddUnboundedTo(*r.From)
+			} else {
+				a.AddRange(*r.From, *r.To)
+			}
+		}
+		return a, true
+	}
+	return nil, false
+}
+
+// addSubAggregation 为分桶聚合添加子聚合
+func addSubAggregation(agg elastic.Aggregation, name string, sub elastic.Aggregation) {
+	switch a := agg.(type) {
+	case *elastic.TermsAggregation:
+		a.SubAggregation(name, sub)
+	case *elastic.DateHistogramAggregation:
+		a.SubAggregation(name, sub)
+	case *elastic.HistogramAggregation:
+		a.SubAggregation(name, sub)
+	case *elastic.RangeAggregation:
+		a.SubAggregation(name, sub)
+	}
+}
+
+// buildAggs 按解析计划生成结构体中所有agg字段的聚合, 与传入的值无关, 值为nil的 nested/obj 结构体中的字段同样生成聚合
+// parent 为当前结构体的字段路径, nestedPath 为所在的nested路径, visiting 记录递归中的解析计划以支持自引用结构
+func (t *StructToEsQuery) buildAggs(plan *Plan, parent string, nestedPath []string, visiting map[*Plan]bool) {
+	if plan == nil || visiting[plan] {
+		return
+	}
+	visiting[plan] = true
+	defer delete(visiting, plan)
+	for _, f := range plan.fields {
+		tags := f.tags
+		if f.anonymous || tags.Block {
+			if tags.Nesting != "innerHits" {
+				t.buildAggs(f.child, parent, nestedPath, visiting)
+			}
+			continue
+		}
+		if tags.Sort != "" {
+			continue
+		}
+		// 与 analysisLogical 一致, 逻辑运算中的 nested@path 增加一层nested
+		fieldParent, fieldPath := parent, nestedPath
+		for _, key := range logicalNested(tags) {
+			fieldParent = joinPath(fieldParent, key)
+			fieldPath = appendPath(fieldPath, fieldParent)
+		}
+		switch tags.Nesting {
+		case "nested", "obj":
+			p := joinPath(fieldParent, f.names[0])
+			np := fieldPath
+			if tags.Nesting == "nested" {
+				np = appendPath(np, p)
+			}
+			t.buildAggs(f.child, p, np, visiting)
+		case "hasChild", "hasParent":
+			// 子/父文档中不能使用聚合, 由 checkAggs 检查
+		case "innerHits":
+		default:
+			if tags.Agg != "" && !tags.Custom {
+				t.setAgg(f, fieldParent, fieldPath)
+			}
+		}
+	}
+}
+
+// checkAggs 检查结构体中的聚合字段, hasChild/hasParent 中的字段属于子/父文档, 在当前文档上没有值, 不能使用agg
+// 聚合名称为字段的json名称, 不同层级的字段名称相同时会相互覆盖, 因此不能重复
+// path 为结构体的字段路径, names 记录已使用的聚合名称及对应的字段路径
+// inJoin 表示在 hasChild/hasParent 结构体中, visiting 记录递归中的解析计划以支持自引用结构
+func checkAggs(plan *Plan, path string, names map[string]string, inJoin bool, visiting map[*Plan]bool) error {
+	if plan == nil || visiting[plan] {
+		return nil
+	}
+	visiting[plan] = true
+	defer delete(visiting, plan)
+	for _, f := range plan.fields {
+		fieldPath := path + "." + f.name
+		if f.tags.Agg != "" && inJoin {
+			return &TagError{Field: fieldPath, Tag: f.tag, Reason: "hasChild/hasParent 中的字段不能使用agg"}
+		}
+		if f.tags.Agg != "" && !f.tags.Custom && f.tags.Sort == "" {
+			if prev, ok := names[f.key]; ok {
+				reason := "聚合名称 " + f.key + " 与 " + prev + " 重复, 需要使用不同的json名称"
+				return &TagError{Field: fieldPath, Tag: f.tag, Reason: reason}
+			}
+			names[f.key] = fieldPath
+		}
+		if f.child == nil || f.tags.Sort != "" {
+			continue
+		}
+		if err := checkAggs(f.child, fieldPath, names, inJoin || isJoin(f.tags.Nesting), visiting); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logicalNested 字段的逻辑运算中 nested@path 的path, 每个path增加一层nested
+func logicalNested(tags *esTags) (keys []string) {
+	for j, logical := range tags.Logical {
+		if ss := strings.Split(logical, "@"); j > 0 && ss[0] == "nested" {
+			keys = append(keys, ss[1])
+		}
+	}
+	return
+}
+
+// joinPath 拼接字段路径
+func joinPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// appendPath 复制nested路径并追加path, 避免修改上层的路径
+func appendPath(paths []string, path string) []string {
+	res := make([]string, len(paths), len(paths)+1)
+	copy(res, paths)
+	return append(res, path)
+}
+
+// setAgg 为字段添加聚合, 聚合名称为字段的json名称
+// nested中的字段自动使用同名的nested聚合逐层包装, 分桶聚合自动添加 reverse_nested 子聚合
+func (t *StructToEsQuery) setAgg(f *planField, parent string, nestedPath []string) {
+	field := joinPath(parent, f.names[0])
+	agg, bucket := newAggregation(f.tags, field)
+	if agg == nil {
+		return
+	}
+	if bucket && len(nestedPath) > 0 {
+		addSubAggregation(agg, reverseNestedName, elastic.NewReverseNestedAggregation())
+	}
+	for i := len(nestedPath) - 1; i >= 0; i-- {
+		agg = elastic.NewNestedAggregation().Path(nestedPath[i]).SubAggregation(f.key, agg)
+	}
+	root := t.getRoot()
+	if root.aggs == nil {
+		root.aggs = make(map[string]elastic.Aggregation)
+	}
+	root.aggs[f.key] = agg
+}
+
+// GetAggregations 获取结构体中agg tag生成的聚合, 需要在 ToQuery 之后调用
+func (t *StructToEsQuery) GetAggregations() map[string]elastic.Aggregation {
+	return t.getRoot().aggs
+}
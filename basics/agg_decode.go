@@ -0,0 +1,196 @@
+package basics
+
+import (
+	"encoding/json"
+	"errors"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/olivere/elastic"
+	"reflect"
+	"strings"
+)
+
+// AggBucket 分桶聚合(terms/histogram/date_histogram/range)的桶
+// 作为匿名字段嵌入自定义结构体时, 结构体中的其它字段按json名称解码子聚合
+type AggBucket struct {
+	Key          interface{} `json:"key"`
+	KeyAsString  *string     `json:"key_as_string,omitempty"`
+	DocCount     int64       `json:"doc_count"`
+	From         *float64    `json:"from,omitempty"`
+	To           *float64    `json:"to,omitempty"`
+	RootDocCount *int64      `json:"-"` // nested 中的分桶聚合由 reverse_nested 统计的根文档数
+}
+
+// AggStats stats聚合的结果
+type AggStats struct {
+	Count int64    `json:"count"`
+	Min   *float64 `json:"min"`
+	Max   *float64 `json:"max"`
+	Avg   *float64 `json:"avg"`
+	Sum   *float64 `json:"sum"`
+}
+
+var aggBucketType = reflect.TypeOf(AggBucket{})
+
+// DecodeAggs 将聚合结果解码到 out, out 为结构体指针, 字段按json名称对应聚合名称
+//   - 分桶聚合对应切片, 元素为 AggBucket 或嵌入 AggBucket 的结构体(可包含子聚合字段)
+//   - 指标聚合对应 *float64 等数字类型, 没有文档时值为null, 字段为nil, stats 聚合对应 AggStats
+//   - nested/filter 等与内层聚合同名的单桶包装聚合会自动展开
+//   - 其它结构体字段作为单桶聚合, 按json名称解码其子聚合
+func DecodeAggs(aggs elastic.Aggregations, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("out 必须为结构体指针")
+	}
+	m := make(map[string]json.RawMessage, len(aggs))
+	for name, raw := range aggs {
+		if raw != nil {
+			m[name] = *raw
+		}
+	}
+	return decodeAggs(m, rv.Elem())
+}
+
+// DecodeAggs 将查询结果中的聚合解码到 out, 规则同 DecodeAggs
+func (t *SearchResult) DecodeAggs(out interface{}) error {
+	return DecodeAggs(t.Aggregations, out)
+}
+
+func decodeAggs(m map[string]json.RawMessage, v reflect.Value) error {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		tt := typ.Field(i)
+		fv := v.Field(i)
+		if tt.Type == aggBucketType || !fv.CanSet() {
+			continue
+		}
+		name := strings.Split(tt.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if tt.Anonymous && name == "" && tt.Type.Kind() == reflect.Struct {
+			if err := decodeAggs(m, fv); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" {
+			name = tt.Name
+		}
+		raw, ok := m[name]
+		if !ok {
+			continue
+		}
+		obj, err := unwrapAgg(name, raw)
+		if err != nil {
+			return err
+		}
+		if err = decodeAgg(obj, fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unwrapAgg 展开与内层聚合同名的单桶包装聚合(nested/filter/reverse_nested)
+func unwrapAgg(name string, raw json.RawMessage) (obj map[string]json.RawMessage, err error) {
+	for {
+		obj = nil
+		if err = jsoniter.Unmarshal(raw, &obj); err != nil {
+			return
+		}
+		inner, ok := obj[name]
+		if !ok {
+			return
+		}
+		raw = inner
+	}
+}
+
+func decodeAgg(obj map[string]json.RawMessage, v reflect.Value) error {
+	typ := v.Type()
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Slice:
+		return decodeBuckets(obj["buckets"], v)
+	case reflect.Struct:
+		if typ == reflect.TypeOf(AggStats{}) {
+			b, _ := jsoniter.Marshal(obj)
+			return jsoniter.Unmarshal(b, v.Addr().Interface())
+		}
+		if v.Kind() == reflect.Ptr {
+			v.Set(reflect.New(typ))
+			v = v.Elem()
+		}
+		return decodeAggs(obj, v)
+	default:
+		// 指标聚合
+		// 没有文档时 min/max/avg 等聚合的值为null, 此时字段保持nil(零值)
+		value, ok := obj["value"]
+		if !ok {
+			return nil
+		}
+		if len(value) == 0 || string(value) == "null" {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		return jsoniter.Unmarshal(value, v.Addr().Interface())
+	}
+}
+
+func decodeBuckets(raw json.RawMessage, slice reflect.Value) error {
+	var buckets []map[string]json.RawMessage
+	if len(raw) > 0 {
+		if err := jsoniter.Unmarshal(raw, &buckets); err != nil {
+			return err
+		}
+	}
+	res := reflect.MakeSlice(slice.Type(), len(buckets), len(buckets))
+	elemType := slice.Type().Elem()
+	for i, obj := range buckets {
+		elem := res.Index(i)
+		if elemType.Kind() == reflect.Ptr {
+			elem.Set(reflect.New(elemType.Elem()))
+			elem = elem.Elem()
+		}
+		if elem.Kind() != reflect.Struct {
+			return errors.New("桶切片的元素必须为结构体")
+		}
+		if bucket := findAggBucket(elem); bucket.IsValid() {
+			b, _ := jsoniter.Marshal(obj)
+			if err := jsoniter.Unmarshal(b, bucket.Addr().Interface()); err != nil {
+				return err
+			}
+			if rn, ok := obj[reverseNestedName]; ok {
+				var root struct {
+					DocCount int64 `json:"doc_count"`
+				}
+				if err := jsoniter.Unmarshal(rn, &root); err == nil {
+					bucket.FieldByName("RootDocCount").Set(reflect.ValueOf(&root.DocCount))
+				}
+			}
+		}
+		if elem.Type() != aggBucketType {
+			if err := decodeAggs(obj, elem); err != nil {
+				return err
+			}
+		}
+	}
+	slice.Set(res)
+	return nil
+}
+
+// findAggBucket 元素本身为 AggBucket 或嵌入了 AggBucket 时返回对应的值
+func findAggBucket(v reflect.Value) reflect.Value {
+	if v.Type() == aggBucketType {
+		return v
+	}
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		if typ.Field(i).Anonymous && typ.Field(i).Type == aggBucketType {
+			return v.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
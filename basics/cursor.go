@@ -0,0 +1,84 @@
+package basics
+
+import (
+	"encoding/base64"
+	"errors"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/olivere/elastic"
+)
+
+// cursorJson 解码游标时保留数字原文, 避免long类型的排序值丢失精度
+var cursorJson = jsoniter.Config{UseNumber: true}.Froze()
+
+// esCursor EsInnerHits 的可选接口, 实现后支持 search_after 游标分页
+type esCursor interface {
+	// GetCursor 返回nil表示使用 from/size 分页, 返回空字符串表示游标分页的第一页
+	GetCursor() *string
+}
+
+// EncodeCursor 将排序值编码为URL安全的游标
+func EncodeCursor(sort []interface{}) string {
+	if len(sort) == 0 {
+		return ""
+	}
+	b, err := cursorJson.Marshal(sort)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor 解码 EncodeCursor 生成的游标, 空字符串返回nil
+func DecodeCursor(cursor string) (res []interface{}, err error) {
+	if cursor == "" {
+		return
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errors.New("cursor 格式错误")
+	}
+	if err = cursorJson.Unmarshal(b, &res); err != nil {
+		return nil, errors.New("cursor 格式错误")
+	}
+	return
+}
+
+// NextCursor 返回最后一条命中记录对应的游标, 没有命中记录时返回空字符串
+func NextCursor(hits *elastic.SearchHits) string {
+	if hits == nil || len(hits.Hits) == 0 {
+		return ""
+	}
+	return EncodeCursor(hits.Hits[len(hits.Hits)-1].Sort)
+}
+
+// nextCursor 当前页已满时返回下一页的游标
+func nextCursor(hits *elastic.SearchHits, size int) string {
+	if hits == nil || len(hits.Hits) < size {
+		return ""
+	}
+	return NextCursor(hits)
+}
+
+// withTiebreaker 游标分页时在排序最后添加 _id 保证排序值唯一
+func withTiebreaker(sorters []elastic.Sorter) []elastic.Sorter {
+	if n := len(sorters); n > 0 {
+		if info, ok := sorters[n-1].(elastic.SortInfo); ok && info.Field == "_id" {
+			return sorters
+		}
+	}
+	return append(sorters, elastic.SortInfo{Field: "_id", Ascending: true})
+}
+
+// getCursor 根节点 innerHits 开启游标分页时返回游标
+func (t *StructToEsQuery) getCursor() *string {
+	if c, ok := t.getRoot().innerHits.(esCursor); ok {
+		return c.GetCursor()
+	}
+	return nil
+}
+
+// SetCursor 使用游标分页, cursor 为上一页返回的游标, 第一页传空字符串
+func (t *SearchBody) SetCursor(cursor string) *SearchBody {
+	t.Cursor = &cursor
+	return t
+}
@@ -0,0 +1,72 @@
+package basics
+
+import (
+	"github.com/olivere/elastic"
+)
+
+// esCustom 保存自定义查询/排序回调, 每个 StructToEsQuery 实例独立持有
+// 按字段名注册的回调优先, 未注册的字段使用默认回调
+type esCustom struct {
+	searchDefault func(string) []elastic.Query
+	sorterDefault func(string) []elastic.Sorter
+	searches      map[string]func(string) []elastic.Query
+	sorters       map[string]func(string) []elastic.Sorter
+}
+
+func newEsCustom() *esCustom {
+	return &esCustom{
+		searches: make(map[string]func(string) []elastic.Query),
+		sorters:  make(map[string]func(string) []elastic.Sorter),
+	}
+}
+
+func (t *esCustom) search(field string) []elastic.Query {
+	if fn := t.searches[field]; fn != nil {
+		return fn(field)
+	}
+	if t.searchDefault != nil {
+		return t.searchDefault(field)
+	}
+	return nil
+}
+
+func (t *esCustom) sorter(field string) []elastic.Sorter {
+	if fn := t.sorters[field]; fn != nil {
+		return fn(field)
+	}
+	if t.sorterDefault != nil {
+		return t.sorterDefault(field)
+	}
+	return nil
+}
+
+func (t *StructToEsQuery) getCustom() *esCustom {
+	if t.custom == nil {
+		t.custom = newEsCustom()
+	}
+	return t.custom
+}
+
+// SetCustomSearch 设置默认的自定义查询回调, 未通过 RegisterSearch 注册的 custom 字段使用该回调
+func (t *StructToEsQuery) SetCustomSearch(customSearch func(string) []elastic.Query) *StructToEsQuery {
+	t.getRoot().getCustom().searchDefault = customSearch
+	return t
+}
+
+// SetCustomSorter 设置默认的自定义排序回调, 未通过 RegisterSorter 注册的 custom 字段使用该回调
+func (t *StructToEsQuery) SetCustomSorter(customSorter func(string) []elastic.Sorter) *StructToEsQuery {
+	t.getRoot().getCustom().sorterDefault = customSorter
+	return t
+}
+
+// RegisterSearch 为指定字段注册自定义查询回调, field 为 fields > field > json > 字段名 中的第一个名称
+func (t *StructToEsQuery) RegisterSearch(field string, customSearch func(string) []elastic.Query) *StructToEsQuery {
+	t.getRoot().getCustom().searches[field] = customSearch
+	return t
+}
+
+// RegisterSorter 为指定字段注册自定义排序回调, field 取值规则同 RegisterSearch
+func (t *StructToEsQuery) RegisterSorter(field string, customSorter func(string) []elastic.Sorter) *StructToEsQuery {
+	t.getRoot().getCustom().sorters[field] = customSorter
+	return t
+}
@@ -0,0 +1,122 @@
+package basics
+
+import (
+	"errors"
+	"fmt"
+	"github.com/olivere/elastic"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// dateMathRegexp 只允许以now开头的日期计算, 如 now, now-7d, now-1M/M
+var dateMathRegexp = regexp.MustCompile(`^now((?:[+-]\d+[yMwdhHms])*)(/[yMwdhHms])?$`)
+
+// dateRoundRegexp round 的格式, 如 /d
+var dateRoundRegexp = regexp.MustCompile(`^/[yMwdhHms]$`)
+
+// dateMathUnits dateMath 未设置时允许的单位
+const dateMathUnits = "yMwd"
+
+// checkDateRange 检查 dateRange 相关的tag
+func checkDateRange(tags *esTags) error {
+	if tags.TimeZone != "" {
+		if _, err := time.LoadLocation(tags.TimeZone); err != nil && !strings.ContainsAny(tags.TimeZone, "+-") {
+			return errors.New("timeZone: " + tags.TimeZone + " 不存在")
+		}
+	}
+	if tags.Round != "" && !dateRoundRegexp.MatchString(tags.Round) {
+		return errors.New("round 格式错误, 如: /d")
+	}
+	for _, r := range tags.DateMath {
+		if !strings.ContainsRune("yMwdhHms", r) {
+			return fmt.Errorf("dateMath: %c 不存在", r)
+		}
+	}
+	if tags.TimeZone != "" && tags.Relational != "dateRange" && tags.Agg != "date_histogram" {
+		return errors.New("timeZone 只能用于dateRange或date_histogram")
+	}
+	if tags.Relational != "dateRange" && (tags.Round != "" || tags.DateMath != "") {
+		return errors.New("round/dateMath 只能用于dateRange")
+	}
+	return nil
+}
+
+// dateValue 处理 dateRange 的单个值, 空字符串返回nil
+//   - 以now开头的值作为日期计算, 只能使用 dateMath 中的单位, 否则返回错误
+//   - 其它值按 format 由es解析
+//   - 设置了 round 时追加取整, 日期计算直接追加, 其它值使用 || 连接
+func (t *StructToEsQuery) dateValue(val interface{}) (interface{}, error) {
+	s, ok := val.(string)
+	if !ok {
+		return val, nil
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	units := t.tags.DateMath
+	if units == "" {
+		units = dateMathUnits
+	}
+	if strings.HasPrefix(s, "now") {
+		m := dateMathRegexp.FindStringSubmatch(s)
+		if m == nil {
+			return nil, errors.New("日期格式错误: " + s)
+		}
+		for _, r := range m[1] + m[2] {
+			if r >= 'A' && !strings.ContainsRune(units, r) {
+				return nil, errors.New("日期计算不支持的单位: " + string(r))
+			}
+		}
+		if m[2] == "" && t.tags.Round != "" {
+			s += t.tags.Round
+		}
+		return s, nil
+	}
+	if strings.Contains(s, "||") {
+		return nil, errors.New("日期格式错误: " + s)
+	}
+	if t.tags.Round != "" {
+		s += "||" + t.tags.Round
+	}
+	return s, nil
+}
+
+// getDateVal 读取 dateRange 的值, 结果固定为 [gte, lte], 不限制时对应位置为nil, 都不限制时返回nil
+func (t *StructToEsQuery) getDateVal(v reflect.Value) ([]interface{}, error) {
+	vv := t.getVal(v)
+	res := make([]interface{}, 2)
+	has := false
+	for i := 0; i < len(vv) && i < 2; i++ {
+		val, err := t.dateValue(vv[i])
+		if err != nil {
+			return nil, err
+		}
+		res[i] = val
+		has = has || val != nil
+	}
+	if !has {
+		return nil, nil
+	}
+	return res, nil
+}
+
+// dateRangeQuery 生成日期范围查询
+func (t *StructToEsQuery) dateRangeQuery(name string) elastic.Query {
+	q := t.newRangeQuery(name)
+	if t.val[0] != nil {
+		q.Gte(t.val[0])
+	}
+	if t.val[1] != nil {
+		q.Lte(t.val[1])
+	}
+	if t.tags.Format != "" {
+		q.Format(t.tags.Format)
+	}
+	if t.tags.TimeZone != "" {
+		q.TimeZone(t.tags.TimeZone)
+	}
+	return q
+}
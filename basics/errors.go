@@ -0,0 +1,140 @@
+package basics
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// TagError 结构体tag错误, 由 Compile/Validate 返回, ToQuery 等方法遇到时panic该错误
+type TagError struct {
+	Field  string // 字段路径, 如 Form.Nested.Id
+	Tag    string // es tag 原文
+	Reason string
+}
+
+func (e *TagError) Error() string {
+	return fmt.Sprintf("%s `es:\"%s\"`: %s", e.Field, e.Tag, e.Reason)
+}
+
+// InputError 传入的值不合法, 如 dateRange 中不允许的日期计算, 由 ToQueryE/ToSearchBodyE/Search 等方法返回
+// ToQuery/ToSearchBody 遇到该错误时不会panic, 生成不匹配任何文档的查询, 错误通过 StructToEsQuery.Err 获取
+type InputError struct {
+	Field  string // 字段的json名称
+	Reason string
+}
+
+func (e *InputError) Error() string {
+	return e.Field + ": " + e.Reason
+}
+
+// relationals 支持的 relational, 空字符串表示 term/terms
+var relationals = map[string]bool{
+	"":                  true,
+	"match":             true,
+	"matchAnd":          true,
+	"range":             true,
+	"rangeLte":          true,
+	"rangeIgnore0":      true,
+	"rangeLteIgnore0":   true,
+	"lt":                true,
+	"lte":               true,
+	"gt":                true,
+	"gte":               true,
+	"prefix":            true,
+	"wildcard":          true,
+	"regexp":            true,
+	"exists":            true,
+	"matchPhrase":       true,
+	"matchPhrasePrefix": true,
+	"multiMatch":        true,
+	"simpleQueryString": true,
+	"queryString":       true,
+	"geoDistance":       true,
+	"geoBoundingBox":    true,
+	"geoPolygon":        true,
+	"dateRange":         true,
+	"overlap":           true,
+	"parentId":          true,
+}
+
+// Validate 检查结构体的tag是否正确, 可在单元测试或服务启动时调用
+func Validate(form interface{}) error {
+	_, err := Compile(reflect.TypeOf(form))
+	return err
+}
+
+// checkTags 检查解析后的tag, 返回错误原因
+func checkTags(tags *esTags) error {
+	switch tags.Nesting {
+	case "", "nested", "obj", "innerHits", "hasChild", "hasParent":
+	default:
+		return errors.New("nesting: " + tags.Nesting + " 不存在")
+	}
+	switch tags.Sort {
+	case "", "default", "val", "nested", "geo":
+	default:
+		return errors.New("sort: " + tags.Sort + " 不存在")
+	}
+	if !relationals[tags.Relational] {
+		return errors.New("relational: " + tags.Relational + " 不存在")
+	}
+	if err := checkAgg(tags); err != nil {
+		return err
+	}
+	if err := checkTermLevel(tags); err != nil {
+		return err
+	}
+	if err := checkMatch(tags); err != nil {
+		return err
+	}
+	if err := checkQueryString(tags); err != nil {
+		return err
+	}
+	if err := checkGeo(tags); err != nil {
+		return err
+	}
+	if err := checkDateRange(tags); err != nil {
+		return err
+	}
+	if err := checkRange(tags); err != nil {
+		return err
+	}
+	if err := checkGroups(tags); err != nil {
+		return err
+	}
+	if err := checkScore(tags); err != nil {
+		return err
+	}
+	if err := checkJoin(tags); err != nil {
+		return err
+	}
+	if err := checkNested(tags); err != nil {
+		return err
+	}
+	if err := checkHighlight(tags); err != nil {
+		return err
+	}
+	length := len(tags.Logical)
+	for j, logical := range tags.Logical {
+		ss := strings.Split(logical, "@")
+		if len(ss) > 1 && j == length-1 && tags.Nesting != "innerHits" {
+			return errors.New("分组不能作为最后一个逻辑运算")
+		}
+		switch ss[0] {
+		case "must", "not", "should", "filter":
+		case "postFilter":
+			if j != 0 {
+				return errors.New("postFilter 只能作为第一个逻辑运算")
+			}
+		case "nested":
+			if len(ss) < 2 || ss[1] == "" {
+				return errors.New("nested 需要指定path, 如 nested@path")
+			}
+		default:
+			return errors.New(ss[0] + ": 仅支持传入 must, not, should, filter, postFilter")
+		}
+	}
+	return nil
+}
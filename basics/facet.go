@@ -0,0 +1,111 @@
+package basics
+
+import (
+	"github.com/olivere/elastic"
+)
+
+// esFacet facet 模式下聚合字段的查询条件, 字段在分组中时为最外层的分组, 分组中的多个聚合字段共用
+type esFacet struct {
+	keys []string // 使用该条件的聚合名称
+	not  bool
+	node *StructToEsQuery
+}
+
+// SetFacet 设置 facet 模式(多选筛选)
+// facet 模式下带 agg tag 的字段的查询条件放入 post_filter, 不影响自身聚合的统计,
+// 每个聚合使用同名的 filter 聚合包装, 只使用其它聚合字段的查询条件过滤
+func (t *StructToEsQuery) SetFacet(facet bool) *StructToEsQuery {
+	t.getRoot().facetMode = facet
+	return t
+}
+
+// setFacet 将聚合字段的查询条件从 query 中移出, 由 buildFacets 统一处理
+// 字段在分组中时移出整个分组, 保持分组内 should/dis_max 等的语义
+func (t *StructToEsQuery) setFacet(f *planField) {
+	node := t
+	logical := f.tags.Logical[len(f.tags.Logical)-1]
+	if t.outerGroup != nil {
+		node, logical = t.outerGroup, t.outerLogical
+	}
+	node.facet = true
+	root := t.getRoot()
+	for _, facet := range root.facets {
+		if facet.node == node {
+			facet.keys = append(facet.keys, f.key)
+			return
+		}
+	}
+	root.facets = append(root.facets, &esFacet{keys: []string{f.key}, not: logical == "not", node: node})
+}
+
+// hasKey 聚合是否使用该条件
+func (t *esFacet) hasKey(key string) bool {
+	for _, k := range t.keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// facetQuery 聚合字段的查询条件, nested 中的字段逐层使用 nested 查询包装
+func (t *esFacet) facetQuery() []elastic.Query {
+	var querys []elastic.Query
+	if t.node.type_ == "group" {
+		querys = t.node.toQuery()
+	} else {
+		querys = t.node.valToQuery()
+	}
+	if len(querys) == 0 {
+		return nil
+	}
+	var q elastic.Query = elastic.NewBoolQuery().Must(querys...)
+	if t.not {
+		q = elastic.NewBoolQuery().MustNot(querys...)
+	}
+	return []elastic.Query{t.node.wrapNested(q)}
+}
+
+// wrapNested nested 中的字段生成的查询逐层使用 nested 查询包装
+func (t *StructToEsQuery) wrapNested(q elastic.Query) elastic.Query {
+	paths := t.nestedPath
+	for i := len(paths) - 1; i >= 0; i-- {
+		q = elastic.NewNestedQuery(paths[i], q)
+	}
+	return q
+}
+
+// buildFacets 生成 post_filter, facet 模式下为每个聚合添加 filter 聚合包装
+func (t *StructToEsQuery) buildFacets() {
+	root := t.getRoot()
+	var querys []elastic.Query
+	if qs := root.mapToQuery(root.getLogical("postFilter")); len(qs) > 0 {
+		querys = append(querys, qs...)
+	}
+	facetQuerys := make([][]elastic.Query, len(root.facets))
+	for i, facet := range root.facets {
+		facetQuerys[i] = facet.facetQuery()
+		querys = append(querys, facetQuerys[i]...)
+	}
+	if len(querys) > 0 {
+		root.postFilter = elastic.NewBoolQuery().Filter(querys...)
+	}
+	if !root.facetMode {
+		return
+	}
+	// 聚合按解析计划生成, 没有传入值的聚合字段同样使用其它聚合字段的查询条件过滤
+	for key, agg := range root.aggs {
+		filter := elastic.NewBoolQuery()
+		for i, qs := range facetQuerys {
+			if !root.facets[i].hasKey(key) {
+				filter.Filter(qs...)
+			}
+		}
+		root.aggs[key] = elastic.NewFilterAggregation().Filter(filter).SubAggregation(key, agg)
+	}
+}
+
+// GetPostFilter 获取 postFilter 字段及 facet 模式下聚合字段生成的 post_filter, 需要在 ToQuery 之后调用
+func (t *StructToEsQuery) GetPostFilter() *elastic.BoolQuery {
+	return t.getRoot().postFilter
+}
@@ -0,0 +1,405 @@
+package basics
+
+import (
+	"errors"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/olivere/elastic"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// GeoPoint 经纬度, 可以解析:
+//   - 字符串 "lat,lon"
+//   - 数组 [lon,lat], 与es一致经度在前
+//   - 对象 {"lat":lat,"lon":lon}
+//
+// 数字也可以是数字字符串
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// GeoDistance 中心点和距离, 可以解析:
+//   - 字符串 "lat,lon" 或 "lat,lon,distance"
+//   - 数组 [lon,lat] 或 [lon,lat,distance]
+//   - 对象 {"lat":lat,"lon":lon,"distance":distance} 或 {"point":GeoPoint,"distance":distance}
+//
+// 未传入距离时使用tag中的distance
+type GeoDistance struct {
+	GeoPoint
+	Distance string `json:"distance"`
+}
+
+// GeoBox 矩形范围, 可以解析:
+//   - 字符串 "top,left,bottom,right"
+//   - 数组 [top,left,bottom,right] 或 [GeoPoint,GeoPoint], 分别为左上角和右下角
+//   - 对象 {"top_left":GeoPoint,"bottom_right":GeoPoint} 或 {"top":top,"left":left,"bottom":bottom,"right":right}
+type GeoBox struct {
+	TopLeft     GeoPoint `json:"top_left"`
+	BottomRight GeoPoint `json:"bottom_right"`
+}
+
+// GeoPolygon 多边形的顶点, 解析 GeoPoint 数组, 至少需要3个点
+type GeoPolygon []GeoPoint
+
+var errGeoPoint = errors.New("经纬度格式错误")
+
+// geoFloat 解析数字或数字字符串
+func geoFloat(v interface{}) (float64, error) {
+	switch val := v.(type) {
+	case float64:
+		return val, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(val), 64)
+	}
+	return 0, errGeoPoint
+}
+
+// geoString 解析距离, 纯数字时单位为米
+func geoString(v interface{}) (string, error) {
+	switch val := v.(type) {
+	case string:
+		return strings.TrimSpace(val), nil
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64) + "m", nil
+	}
+	return "", errors.New("距离格式错误")
+}
+
+// geoDecode 将json解码为 interface{}, 字符串按英文逗号拆分为数组, null 和空字符串返回nil
+func geoDecode(b []byte) (interface{}, error) {
+	var val interface{}
+	if err := jsoniter.Unmarshal(b, &val); err != nil {
+		return nil, err
+	}
+	if s, ok := val.(string); ok {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return nil, nil
+		}
+		ss := strings.Split(s, ",")
+		res := make([]interface{}, len(ss))
+		for i, v := range ss {
+			res[i] = v
+		}
+		return res, nil
+	}
+	return val, nil
+}
+
+func (t *GeoPoint) set(lat, lon interface{}) (err error) {
+	if t.Lat, err = geoFloat(lat); err != nil {
+		return errGeoPoint
+	}
+	if t.Lon, err = geoFloat(lon); err != nil {
+		return errGeoPoint
+	}
+	if t.Lat < -90 || t.Lat > 90 || t.Lon < -180 || t.Lon > 180 {
+		return errors.New("经纬度超出范围")
+	}
+	return nil
+}
+
+// from 从解码后的值中读取经纬度, 字符串拆分后的数组为 lat,lon, json数组为 lon,lat
+func (t *GeoPoint) from(val interface{}, latFirst bool) error {
+	switch v := val.(type) {
+	case []interface{}:
+		if len(v) != 2 {
+			return errGeoPoint
+		}
+		if latFirst {
+			return t.set(v[0], v[1])
+		}
+		return t.set(v[1], v[0])
+	case map[string]interface{}:
+		return t.set(v["lat"], v["lon"])
+	}
+	return errGeoPoint
+}
+
+// fromJson 解析对象中的点, 点可以是 "lat,lon" 字符串
+func (t *GeoPoint) fromJson(val interface{}) error {
+	if s, ok := val.(string); ok {
+		ss := strings.Split(s, ",")
+		if len(ss) != 2 {
+			return errGeoPoint
+		}
+		return t.set(ss[0], ss[1])
+	}
+	return t.from(val, false)
+}
+
+func (t *GeoPoint) UnmarshalJSON(b []byte) error {
+	val, err := geoDecode(b)
+	if err != nil || val == nil {
+		return err
+	}
+	return t.from(val, b[0] == '"')
+}
+
+func (t *GeoDistance) UnmarshalJSON(b []byte) error {
+	val, err := geoDecode(b)
+	if err != nil || val == nil {
+		return err
+	}
+	switch v := val.(type) {
+	case []interface{}:
+		if len(v) == 3 {
+			if t.Distance, err = geoString(v[2]); err != nil {
+				return err
+			}
+			v = v[:2]
+		}
+		return t.GeoPoint.from(v, b[0] == '"')
+	case map[string]interface{}:
+		if d, ok := v["distance"]; ok {
+			if t.Distance, err = geoString(d); err != nil {
+				return err
+			}
+		}
+		if p, ok := v["point"]; ok {
+			return t.GeoPoint.fromJson(p)
+		}
+		return t.GeoPoint.from(v, false)
+	}
+	return errGeoPoint
+}
+
+func (t *GeoBox) UnmarshalJSON(b []byte) error {
+	val, err := geoDecode(b)
+	if err != nil || val == nil {
+		return err
+	}
+	switch v := val.(type) {
+	case []interface{}:
+		switch len(v) {
+		case 4:
+			if err = t.TopLeft.set(v[0], v[1]); err != nil {
+				return err
+			}
+			err = t.BottomRight.set(v[2], v[3])
+		case 2:
+			if err = t.TopLeft.fromJson(v[0]); err != nil {
+				return err
+			}
+			err = t.BottomRight.fromJson(v[1])
+		default:
+			return errors.New("矩形范围格式错误")
+		}
+	case map[string]interface{}:
+		if _, ok := v["top_left"]; ok {
+			if err = t.TopLeft.fromJson(v["top_left"]); err != nil {
+				return err
+			}
+			err = t.BottomRight.fromJson(v["bottom_right"])
+		} else {
+			if err = t.TopLeft.set(v["top"], v["left"]); err != nil {
+				return err
+			}
+			err = t.BottomRight.set(v["bottom"], v["right"])
+		}
+	default:
+		return errors.New("矩形范围格式错误")
+	}
+	if err != nil {
+		return err
+	}
+	if t.TopLeft.Lat < t.BottomRight.Lat {
+		return errors.New("矩形范围的上边界不能小于下边界")
+	}
+	return nil
+}
+
+func (t *GeoPolygon) UnmarshalJSON(b []byte) error {
+	if s := strings.TrimSpace(string(b)); s == "null" || s == `""` {
+		return nil
+	}
+	var val []GeoPoint
+	if err := jsoniter.Unmarshal(b, &val); err != nil {
+		return err
+	}
+	if len(val) < 3 {
+		return errors.New("多边形至少需要3个点")
+	}
+	*t = val
+	return nil
+}
+
+// geoUnits es支持的距离单位
+var geoUnits = map[string]bool{
+	"mi": true, "miles": true, "yd": true, "yards": true, "ft": true, "feet": true, "in": true, "inch": true,
+	"km": true, "kilometers": true, "m": true, "meters": true, "cm": true, "centimeters": true,
+	"mm": true, "millimeters": true, "NM": true, "nmi": true, "nauticalmiles": true,
+}
+
+// checkGeo 检查 geoDistance/geoBoundingBox/geoPolygon/sort:geo 相关的tag
+func checkGeo(tags *esTags) error {
+	switch tags.DistanceType {
+	case "", "arc", "plane":
+	default:
+		return errors.New("distanceType: " + tags.DistanceType + " 不存在")
+	}
+	if tags.Unit != "" && !geoUnits[tags.Unit] {
+		return errors.New("unit: " + tags.Unit + " 不存在")
+	}
+	if (tags.Unit != "" || tags.DistanceField != "") && tags.Sort != "geo" {
+		return errors.New("unit/distanceField 只能用于sort:geo")
+	}
+	return nil
+}
+
+// checkGeoField 检查geo字段的类型, GeoPoint 中没有距离, 用于 geoDistance 时需要在tag中指定distance
+func checkGeoField(typ reflect.Type, tags *esTags) string {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if tags.Relational == "geoDistance" && tags.Distance == "" && typ == reflect.TypeOf(GeoPoint{}) {
+		return "GeoPoint 用于geoDistance时需要指定distance"
+	}
+	return ""
+}
+
+// getGeo 读取 GeoPoint/GeoDistance/GeoBox/GeoPolygon, nil指针, 非指针字段的零值和空的多边形返回nil
+// 指针指向的经纬度为(0,0)的点是有效的值
+func (t *StructToEsQuery) getGeo(v reflect.Value) []interface{} {
+	if v.Kind() != reflect.Ptr && v.IsValid() && v.IsZero() {
+		return nil
+	}
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Invalid || (v.Kind() == reflect.Slice && v.Len() == 0) {
+		return nil
+	}
+	return []interface{}{v.Interface()}
+}
+
+// geoQuery 生成 geo_distance/geo_bounding_box/geo_polygon 查询, 值的类型不匹配时返回nil
+func (t *StructToEsQuery) geoQuery(name string, val interface{}) elastic.Query {
+	switch t.relational {
+	case "geoDistance":
+		var point GeoPoint
+		distance := t.tags.Distance
+		switch v := val.(type) {
+		case GeoDistance:
+			point = v.GeoPoint
+			if v.Distance != "" {
+				distance = v.Distance
+			}
+		case GeoPoint:
+			point = v
+		default:
+			return nil
+		}
+		if distance == "" {
+			return nil
+		}
+		q := elastic.NewGeoDistanceQuery(name).Point(point.Lat, point.Lon).Distance(distance)
+		if t.tags.DistanceType != "" {
+			q.DistanceType(t.tags.DistanceType)
+		}
+		return q
+	case "geoBoundingBox":
+		v, ok := val.(GeoBox)
+		if !ok {
+			return nil
+		}
+		return elastic.NewGeoBoundingBoxQuery(name).
+			TopLeft(v.TopLeft.Lat, v.TopLeft.Lon).
+			BottomRight(v.BottomRight.Lat, v.BottomRight.Lon)
+	default:
+		v, ok := val.(GeoPolygon)
+		if !ok {
+			return nil
+		}
+		q := elastic.NewGeoPolygonQuery(name)
+		for _, p := range v {
+			q.AddPoint(p.Lat, p.Lon)
+		}
+		return q
+	}
+}
+
+// geoDistanceSort 记录解码结果时写入距离的字段
+type geoDistanceSort struct {
+	*elastic.GeoDistanceSort
+	distanceField string
+}
+
+// setGeoSorter 按与传入的点的距离由近到远排序, 传入 GeoPoint, nil指针忽略
+func (t *StructToEsQuery) setGeoSorter(f *planField, val reflect.Value) {
+	tags := f.tags
+	vv := t.getGeo(val)
+	if len(vv) == 0 {
+		return
+	}
+	var point GeoPoint
+	switch v := vv[0].(type) {
+	case GeoPoint:
+		point = v
+	case GeoDistance:
+		point = v.GeoPoint
+	default:
+		return
+	}
+	for _, field := range f.names {
+		if t.parent != "" {
+			field = t.parent + "." + field
+		}
+		sorter := elastic.NewGeoDistanceSort(field).Point(point.Lat, point.Lon).Asc()
+		if tags.Unit != "" {
+			sorter.Unit(tags.Unit)
+		}
+		if tags.DistanceType != "" {
+			sorter.DistanceType(tags.DistanceType)
+		}
+		if tags.Mode != "" {
+			sorter.SortMode(tags.Mode)
+		}
+		t.sorters[tags.Level] = append(t.sorters[tags.Level], &geoDistanceSort{sorter, tags.DistanceField})
+	}
+}
+
+// setDistances 将 sort:geo 计算的距离写入 HitMeta.Distance 和文档中 distanceField 对应的字段
+// 文档没有对应字段的点时es返回的距离为 Infinity, 此时忽略
+func setDistances(sorters []elastic.Sorter, hits *elastic.SearchHits, res *SearchResult, out interface{}) {
+	if hits == nil {
+		return
+	}
+	slice := reflect.ValueOf(out).Elem()
+	first := true
+	for i, sorter := range sorters {
+		gs, ok := sorter.(*geoDistanceSort)
+		if !ok {
+			continue
+		}
+		for j, hit := range hits.Hits {
+			if len(hit.Sort) <= i {
+				continue
+			}
+			d, ok := hit.Sort[i].(float64)
+			if !ok {
+				continue
+			}
+			if first {
+				res.Hits[j].Distance = &d
+			}
+			if gs.distanceField == "" {
+				continue
+			}
+			field := findFieldByPath(slice.Index(j), []string{gs.distanceField})
+			if !field.IsValid() || !field.CanSet() {
+				continue
+			}
+			switch {
+			case field.Kind() == reflect.Float64:
+				field.SetFloat(d)
+			case field.Kind() == reflect.Ptr && field.Type().Elem().Kind() == reflect.Float64:
+				field.Set(reflect.New(field.Type().Elem()))
+				field.Elem().SetFloat(d)
+			}
+		}
+		first = false
+	}
+}
@@ -0,0 +1,61 @@
+package basics
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestGeoUnmarshalJSON(t *testing.T) {
+	p := func(lat, lon float64) GeoPoint { return GeoPoint{Lat: lat, Lon: lon} }
+	tests := []struct {
+		name string
+		raw  string
+		out  interface{} // 解码目标的指针
+		want interface{} // 解码结果, nil 表示应返回错误
+	}{
+		{name: "点字符串", raw: `"30.5, 120.1"`, out: new(GeoPoint), want: p(30.5, 120.1)},
+		{name: "点数组经度在前", raw: `[120.1,30.5]`, out: new(GeoPoint), want: p(30.5, 120.1)},
+		{name: "点对象", raw: `{"lat":"30.5","lon":120.1}`, out: new(GeoPoint), want: p(30.5, 120.1)},
+		{name: "点null", raw: `null`, out: new(GeoPoint), want: GeoPoint{}},
+		{name: "点空字符串", raw: `""`, out: new(GeoPoint), want: GeoPoint{}},
+		{name: "点超出范围", raw: `"91,0"`, out: new(GeoPoint)},
+		{name: "点数量错误", raw: `[1,2,3]`, out: new(GeoPoint)},
+		{name: "点非数字", raw: `"a,b"`, out: new(GeoPoint)},
+
+		{name: "距离字符串", raw: `"30.5,120.1,1km"`, out: new(GeoDistance), want: GeoDistance{p(30.5, 120.1), "1km"}},
+		{name: "距离数组数字", raw: `[120.1,30.5,500]`, out: new(GeoDistance), want: GeoDistance{p(30.5, 120.1), "500m"}},
+		{name: "距离无距离", raw: `"30.5,120.1"`, out: new(GeoDistance), want: GeoDistance{GeoPoint: p(30.5, 120.1)}},
+		{name: "距离对象", raw: `{"lat":30.5,"lon":120.1,"distance":"2km"}`, out: new(GeoDistance), want: GeoDistance{p(30.5, 120.1), "2km"}},
+		{name: "距离point", raw: `{"point":"30.5,120.1","distance":"2km"}`, out: new(GeoDistance), want: GeoDistance{p(30.5, 120.1), "2km"}},
+		{name: "距离格式错误", raw: `[120.1,30.5,true]`, out: new(GeoDistance)},
+
+		{name: "矩形字符串", raw: `"40,100,30,120"`, out: new(GeoBox), want: GeoBox{p(40, 100), p(30, 120)}},
+		{name: "矩形点数组", raw: `[{"lat":40,"lon":100},"30,120"]`, out: new(GeoBox), want: GeoBox{p(40, 100), p(30, 120)}},
+		{name: "矩形对象", raw: `{"top_left":[100,40],"bottom_right":[120,30]}`, out: new(GeoBox), want: GeoBox{p(40, 100), p(30, 120)}},
+		{name: "矩形边界", raw: `{"top":40,"left":100,"bottom":30,"right":120}`, out: new(GeoBox), want: GeoBox{p(40, 100), p(30, 120)}},
+		{name: "矩形上下颠倒", raw: `"30,100,40,120"`, out: new(GeoBox)},
+		{name: "矩形数量错误", raw: `[40,100,30]`, out: new(GeoBox)},
+
+		{name: "多边形", raw: `["30,120",[121,30],{"lat":31,"lon":121}]`, out: new(GeoPolygon),
+			want: GeoPolygon{p(30, 120), p(30, 121), p(31, 121)}},
+		{name: "多边形null", raw: `null`, out: new(GeoPolygon), want: GeoPolygon(nil)},
+		{name: "多边形点不足", raw: `["30,120","30,121"]`, out: new(GeoPolygon)},
+	}
+	for _, tt := range tests {
+		err := json.Unmarshal([]byte(tt.raw), tt.out)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%s: %s 应返回错误", tt.name, tt.raw)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s: %v", tt.name, tt.raw, err)
+			continue
+		}
+		if got := reflect.ValueOf(tt.out).Elem().Interface(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %s = %+v, want %+v", tt.name, tt.raw, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package basics
+
+import (
+	"errors"
+	"github.com/olivere/elastic"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// esGroup 分组的参数, 如 logical:must@a{type:dis_max,tie:0.3},should
+type esGroup struct {
+	Type  string // bool/dis_max/constant_score, 默认bool
+	Tie   *float64
+	Boost *float64
+	Msm   string // bool 的 minimum_should_match
+}
+
+// msmRegexp minimum_should_match 的格式, 如 2, -1, 75%, 3<90%
+var msmRegexp = regexp.MustCompile(`^(-?\d+%?|\d+<-?\d+%?( \d+<-?\d+%?)*)$`)
+
+// splitLogical 按英文逗号拆分logical, 忽略{}中的逗号
+func splitLogical(s string) (res []string) {
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				res = append(res, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(res, s[start:])
+}
+
+// parseLogical 解析logical, 返回去掉分组参数后的logical及每个logical对应的分组参数
+func parseLogical(s string) (logical []string, groups []*esGroup, err error) {
+	logical = splitLogical(s)
+	groups = make([]*esGroup, len(logical))
+	for i, v := range logical {
+		start := strings.IndexByte(v, '{')
+		if start < 0 {
+			continue
+		}
+		if !strings.HasSuffix(v, "}") || !strings.Contains(v[:start], "@") {
+			return nil, nil, errors.New("分组参数格式错误: " + v)
+		}
+		if groups[i], err = parseGroup(v[start+1 : len(v)-1]); err != nil {
+			return nil, nil, err
+		}
+		logical[i] = v[:start]
+	}
+	return
+}
+
+func parseGroup(s string) (*esGroup, error) {
+	res := new(esGroup)
+	for _, v := range strings.Split(s, ",") {
+		kv := strings.SplitN(v, ":", 2)
+		if len(kv) != 2 {
+			return nil, errors.New("分组参数格式错误: " + v)
+		}
+		switch kv[0] {
+		case "type":
+			res.Type = kv[1]
+		case "msm":
+			res.Msm = kv[1]
+		case "tie", "boost":
+			f, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil {
+				return nil, errors.New(kv[0] + "值只能是数字")
+			}
+			if kv[0] == "tie" {
+				res.Tie = &f
+			} else {
+				res.Boost = &f
+			}
+		default:
+			return nil, errors.New("分组参数不存在: " + kv[0])
+		}
+	}
+	return res, nil
+}
+
+// moveGroupOptions 字段的logical中有分组时, 字段上的 msm/groupBoost 作为最后一个分组的参数
+// 没有分组时 msm 用于直接包含该字段的bool查询; boost 始终用于match等查询
+func (t *esTags) moveGroupOptions() {
+	if t.Msm == "" && t.GroupBoost == nil {
+		return
+	}
+	for i := len(t.Logical) - 1; i >= 0; i-- {
+		ss := strings.Split(t.Logical[i], "@")
+		if len(ss) < 2 || ss[0] == "nested" {
+			continue
+		}
+		if t.Groups == nil {
+			t.Groups = make([]*esGroup, len(t.Logical))
+		}
+		g := t.Groups[i]
+		if g == nil {
+			g = new(esGroup)
+			t.Groups[i] = g
+		}
+		if g.Msm == "" {
+			g.Msm = t.Msm
+		}
+		if g.Boost == nil {
+			g.Boost = t.GroupBoost
+		}
+		t.Msm, t.GroupBoost = "", nil
+		return
+	}
+}
+
+// checkGroups 检查分组参数
+func checkGroups(tags *esTags) error {
+	if tags.Msm != "" && !msmRegexp.MatchString(tags.Msm) {
+		return errors.New("msm 格式错误: " + tags.Msm)
+	}
+	if tags.GroupBoost != nil {
+		return errors.New("groupBoost 只能用于logical中有分组的字段")
+	}
+	for _, g := range tags.Groups {
+		if g == nil {
+			continue
+		}
+		switch g.Type {
+		case "", "bool", "constant_score":
+		case "dis_max":
+		default:
+			return errors.New("分组类型不存在: " + g.Type)
+		}
+		if g.Msm != "" && !msmRegexp.MatchString(g.Msm) {
+			return errors.New("msm 格式错误: " + g.Msm)
+		}
+		if g.Msm != "" && g.Type == "dis_max" {
+			return errors.New("msm 不能用于dis_max分组")
+		}
+		if g.Tie != nil && g.Type != "dis_max" {
+			return errors.New("tie 只能用于dis_max分组")
+		}
+	}
+	return nil
+}
+
+// groupQuery 按分组参数包装分组生成的bool查询, querys 为分组中must/should的查询
+// dis_max 分组中 not/filter 的查询不参与评分, 与 dis_max 一起放入外层bool查询的 must_not/filter
+func (t *StructToEsQuery) groupQuery(bq *elastic.BoolQuery, querys, not, filter []elastic.Query) elastic.Query {
+	g := t.group
+	if g.Msm != "" {
+		bq.MinimumShouldMatch(g.Msm)
+	}
+	switch g.Type {
+	case "dis_max":
+		res := elastic.NewBoolQuery().MustNot(not...).Filter(filter...)
+		if len(querys) == 0 {
+			return res
+		}
+		q := elastic.NewDisMaxQuery().Query(querys...)
+		if g.Tie != nil {
+			q.TieBreaker(*g.Tie)
+		}
+		if g.Boost != nil {
+			q.Boost(*g.Boost)
+		}
+		if len(not)+len(filter) == 0 {
+			return q
+		}
+		return res.Must(q)
+	case "constant_score":
+		q := elastic.NewConstantScoreQuery(bq)
+		if g.Boost != nil {
+			q.Boost(*g.Boost)
+		}
+		return q
+	default:
+		if g.Boost != nil {
+			bq.Boost(*g.Boost)
+		}
+		return bq
+	}
+}
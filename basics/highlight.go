@@ -0,0 +1,142 @@
+package basics
+
+import (
+	"errors"
+	"github.com/olivere/elastic"
+	"sort"
+	"strings"
+)
+
+// esHighlight EsInnerHits 的可选接口, 实现后可以由请求控制是否返回高亮
+type esHighlight interface {
+	// GetHighlight 返回nil时使用tag中的设置, 返回false时不返回高亮
+	GetHighlight() *bool
+}
+
+// highlightRelationals 可以使用highlight的 relational
+var highlightRelationals = map[string]bool{
+	"match": true, "matchAnd": true, "matchPhrase": true, "matchPhrasePrefix": true,
+	"multiMatch": true, "simpleQueryString": true, "queryString": true,
+}
+
+// checkHighlight 检查高亮相关的tag
+func checkHighlight(tags *esTags) error {
+	switch tags.Highlight {
+	case "":
+		if tags.FragmentSize != nil || tags.Fragments != nil || len(tags.PreTags)+len(tags.PostTags) > 0 {
+			return errors.New("fragmentSize/fragments/preTags/postTags 只能用于highlight字段")
+		}
+		return nil
+	case "default", "unified", "plain", "fvh":
+	default:
+		return errors.New("highlight: " + tags.Highlight + " 不存在")
+	}
+	if !highlightRelationals[tags.Relational] {
+		return errors.New("highlight 只能用于match系列查询")
+	}
+	if len(tags.PreTags) != len(tags.PostTags) {
+		return errors.New("preTags 和 postTags 的数量需要一致")
+	}
+	return nil
+}
+
+// setHighlight 将字段添加到高亮中, nested/hasChild/hasParent 中的字段添加到所在节点 inner_hits 的高亮中
+// 同时记录es字段名与json名称的对应关系, 用于解码结果
+func (t *StructToEsQuery) setHighlight(f *planField) {
+	tags := f.tags
+	root := t.getRoot()
+	target := root
+	if t.scope != nil {
+		target = t.scope
+	}
+	if target.highlight == nil {
+		target.highlight = elastic.NewHighlight()
+	}
+	if root.highlightKeys == nil {
+		root.highlightKeys = make(map[string]string)
+	}
+	for _, name := range t.fields {
+		// multiMatch/queryString 的字段可能带有权重, 如 title^3
+		name = strings.TrimSpace(strings.Split(name, "^")[0])
+		if t.parent != "" {
+			name = t.parent + "." + name
+		}
+		if _, ok := root.highlightKeys[name]; ok {
+			// 多个字段高亮同一个es字段时使用第一个字段的参数
+			continue
+		}
+		hf := elastic.NewHighlighterField(name)
+		if tags.Highlight != "default" {
+			hf.HighlighterType(tags.Highlight)
+		}
+		if tags.FragmentSize != nil {
+			hf.FragmentSize(*tags.FragmentSize)
+		}
+		if tags.Fragments != nil {
+			hf.NumOfFragments(*tags.Fragments)
+		}
+		if len(tags.PreTags) > 0 {
+			hf.PreTags(tags.PreTags...).PostTags(tags.PostTags...)
+		}
+		target.highlight.Fields(hf)
+		root.highlightKeys[name] = f.key
+	}
+}
+
+// getHighlight 当前节点的高亮, innerHits 关闭高亮时返回nil
+func (t *StructToEsQuery) getHighlight() *elastic.Highlight {
+	if t.highlight == nil {
+		return nil
+	}
+	if h, ok := t.innerHits.(esHighlight); ok {
+		if on := h.GetHighlight(); on != nil && !*on {
+			return nil
+		}
+	}
+	return t.highlight
+}
+
+// setHighlights 将命中记录及其 inner_hits 中的高亮按json名称写入 HitMeta.Highlight
+// keys 中没有的字段使用es中的字段名
+func setHighlights(keys map[string]string, hits *elastic.SearchHits, res *SearchResult) {
+	if hits == nil {
+		return
+	}
+	for i, hit := range hits.Hits {
+		m := make(map[string][]string)
+		collectHighlight(keys, hit, m)
+		if len(m) > 0 {
+			res.Hits[i].Highlight = m
+		}
+	}
+}
+
+// collectHighlight 多个es字段对应同一个json名称时按字段名排序合并, 保证顺序稳定
+func collectHighlight(keys map[string]string, hit *elastic.SearchHit, m map[string][]string) {
+	names := make([]string, 0, len(hit.Highlight))
+	for name := range hit.Highlight {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key, ok := keys[name]
+		if !ok {
+			key = name
+		}
+		m[key] = append(m[key], hit.Highlight[name]...)
+	}
+	names = names[:0]
+	for name := range hit.InnerHits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		inner := hit.InnerHits[name]
+		if inner == nil || inner.Hits == nil {
+			continue
+		}
+		for _, h := range inner.Hits.Hits {
+			collectHighlight(keys, h, m)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package basics
+
+import (
+	"context"
+	"errors"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/olivere/elastic"
+	"reflect"
+	"strings"
+)
+
+// SearchResult 查询结果, Hits 与解码后的文档切片按下标一一对应
+type SearchResult struct {
+	Total        int64
+	MaxScore     *float64
+	Page         int
+	Size         int
+	Hits         []*HitMeta
+	Aggregations elastic.Aggregations
+	Cursor       string // 游标分页时下一页的游标, 为空表示没有下一页
+}
+
+// HitMeta 单条命中记录的元数据
+type HitMeta struct {
+	Id    string
+	Index string
+	Type  string
+	Score *float64
+	Sort  []interface{}
+	// Distance 第一个 sort:geo 排序计算的距离, 单位同tag中的unit, 默认为米
+	Distance *float64
+	// Highlight 高亮片段, key 为查询结构体中字段的json名称, 包括 inner_hits 中的高亮, 由 SearchInto 设置
+	Highlight map[string][]string
+}
+
+func newHitMeta(hit *elastic.SearchHit) *HitMeta {
+	return &HitMeta{
+		Id:    hit.Id,
+		Index: hit.Index,
+		Type:  hit.Type,
+		Score: hit.Score,
+		Sort:  hit.Sort,
+	}
+}
+
+// DecodeHits 将命中记录的 _source 解码到 out, out 必须为切片指针, 如 &[]Doc{} 或 &[]*Doc{}
+// inner_hits 按名称解码到文档中json名称相同的切片字段, 名称包含.时按路径逐级查找
+// 使用 name 指定了名称的 inner_hits 需要使用 SearchInto 解码, 文档中没有同名字段时按对应的nested路径查找
+func DecodeHits(hits *elastic.SearchHits, out interface{}) (res *SearchResult, err error) {
+	return decodeSearchHits(hits, out, nil)
+}
+
+// decodeSearchHits 同 DecodeHits, paths 为 inner_hits 名称与nested路径的对应关系
+func decodeSearchHits(hits *elastic.SearchHits, out interface{}, paths map[string]string) (res *SearchResult, err error) {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return nil, errors.New("out 必须为切片指针")
+	}
+	res = new(SearchResult)
+	slice := rv.Elem()
+	if hits == nil {
+		slice.Set(reflect.MakeSlice(slice.Type(), 0, 0))
+		return
+	}
+	res.Total = hits.TotalHits
+	res.MaxScore = hits.MaxScore
+	if err = decodeHits(hits, slice, paths); err != nil {
+		return
+	}
+	res.Hits = make([]*HitMeta, len(hits.Hits))
+	for i, hit := range hits.Hits {
+		res.Hits[i] = newHitMeta(hit)
+	}
+	return
+}
+
+// DecodeHit 解码单条命中记录, out 为文档结构体指针
+func DecodeHit(hit *elastic.SearchHit, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("out 必须为非空指针")
+	}
+	return decodeHit(hit, rv.Elem(), nil)
+}
+
+// decodeHits 解码到切片, 切片元素可以是结构体或结构体指针
+func decodeHits(hits *elastic.SearchHits, slice reflect.Value, paths map[string]string) error {
+	res := reflect.MakeSlice(slice.Type(), len(hits.Hits), len(hits.Hits))
+	elemType := slice.Type().Elem()
+	for i, hit := range hits.Hits {
+		elem := res.Index(i)
+		if elemType.Kind() == reflect.Ptr {
+			elem.Set(reflect.New(elemType.Elem()))
+			elem = elem.Elem()
+		}
+		if err := decodeHit(hit, elem, paths); err != nil {
+			return err
+		}
+	}
+	slice.Set(res)
+	return nil
+}
+
+func decodeHit(hit *elastic.SearchHit, v reflect.Value, paths map[string]string) error {
+	if hit.Source != nil {
+		if err := jsoniter.Unmarshal(*hit.Source, v.Addr().Interface()); err != nil {
+			return err
+		}
+	}
+	for name, inner := range hit.InnerHits {
+		if inner == nil || inner.Hits == nil {
+			continue
+		}
+		field := findInnerHitsField(v, name)
+		if path, ok := paths[name]; ok && !field.IsValid() {
+			field = findInnerHitsField(v, path)
+		}
+		if !field.IsValid() {
+			continue
+		}
+		if err := decodeHits(inner.Hits, field, paths); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findInnerHitsField 查找 inner_hits 对应的切片字段
+// 多层nested时内层的名称包含外层路径(如 a.b), 依次去掉前缀查找
+func findInnerHitsField(v reflect.Value, name string) reflect.Value {
+	path := strings.Split(name, ".")
+	for i := range path {
+		field := findFieldByPath(v, path[i:])
+		if field.IsValid() && field.Kind() == reflect.Slice && field.CanSet() {
+			return field
+		}
+	}
+	return reflect.Value{}
+}
+
+func findFieldByPath(v reflect.Value, path []string) reflect.Value {
+	for _, name := range path {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}
+		}
+		v = findFieldByJson(v, name)
+		if !v.IsValid() {
+			return v
+		}
+	}
+	return v
+}
+
+// findFieldByJson 按json名称查找字段, 包括匿名字段中的字段
+func findFieldByJson(v reflect.Value, name string) reflect.Value {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		tt := typ.Field(i)
+		json := strings.Split(tt.Tag.Get("json"), ",")[0]
+		if json == "-" {
+			continue
+		}
+		if json == name || (json == "" && tt.Name == name) {
+			return v.Field(i)
+		}
+		if tt.Anonymous && json == "" {
+			fv := v.Field(i)
+			for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				if res := findFieldByJson(fv, name); res.IsValid() {
+					return res
+				}
+			}
+		}
+	}
+	return reflect.Value{}
+}
+
+// SearchInto 查询并将结果解码到 out, out 规则同 DecodeHits
+// 结构体中有 innerHits 分页信息时, 返回结果中的 Page/Size 与查询一致
+func (t *StructToEsQuery) SearchInto(ctx context.Context, req *elastic.SearchService, form interface{}, out interface{}) (*SearchResult, error) {
+	sr, err := t.do(ctx, req, form)
+	if err != nil {
+		return nil, err
+	}
+	res, err := decodeSearchHits(sr.Hits, out, t.getRoot().innerPaths)
+	if err != nil {
+		return nil, err
+	}
+	res.Aggregations = sr.Aggregations
+	setDistances(t.GetSorters(), sr.Hits, res, out)
+	setHighlights(t.getRoot().highlightKeys, sr.Hits, res)
+	if t.innerHits != nil {
+		res.Page = t.innerHits.GetPage()
+		res.Size = t.innerHits.GetSize()
+	}
+	if t.getCursor() != nil {
+		res.Cursor = nextCursor(sr.Hits, res.Size)
+	}
+	return res, nil
+}
+
+// SearchInto 查询并将结果解码到 out, out 规则同 DecodeHits
+func (t *SearchBody) SearchInto(ctx context.Context, req *elastic.SearchService, out interface{}) (*SearchResult, error) {
+	sr, err := t.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	res, err := decodeSearchHits(sr.Hits, out, t.innerPaths)
+	if err != nil {
+		return nil, err
+	}
+	res.Aggregations = sr.Aggregations
+	setDistances(t.Sorter, sr.Hits, res, out)
+	setHighlights(t.highlightKeys, sr.Hits, res)
+	res.Page = t.Page
+	res.Size = t.Size
+	if t.Cursor != nil {
+		res.Cursor = nextCursor(sr.Hits, res.Size)
+	}
+	return res, nil
+}
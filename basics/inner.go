@@ -19,6 +19,9 @@ type EsSelect struct {
 	Size    int          `json:"size"`
 	Include ArrayKeyword `json:"include"` //返回的字段
 	Exclude ArrayKeyword `json:"exclude"` //忽略的字段
+	Cursor  *string      `json:"cursor"`  //游标分页, 传入时忽略page, 第一页传空字符串, 后续传上一页返回的游标
+	// 是否返回高亮, 不传时使用tag中的设置
+	Highlight *bool `json:"highlight"`
 }
 
 func (t *EsSelect) GetPage() int {
@@ -37,6 +40,14 @@ func (t *EsSelect) GetExclude() []string {
 	return t.Exclude
 }
 
+func (t *EsSelect) GetCursor() *string {
+	return t.Cursor
+}
+
+func (t *EsSelect) GetHighlight() *bool {
+	return t.Highlight
+}
+
 func (t *EsSelect) SetSource(req *elastic.SearchService) {
 	if t.Page == 0 {
 		t.Page = 1
@@ -44,7 +55,14 @@ func (t *EsSelect) SetSource(req *elastic.SearchService) {
 	if t.Size == 0 {
 		t.Size = 10
 	}
-	req.From(t.Page*t.Size - t.Size).Size(t.Size)
+	if t.Cursor != nil {
+		req.Size(t.Size)
+		if sort, _ := DecodeCursor(*t.Cursor); len(sort) > 0 {
+			req.SearchAfter(sort...)
+		}
+	} else {
+		req.From(t.Page*t.Size - t.Size).Size(t.Size)
+	}
 	if len(t.Include)+len(t.Exclude) > 0 {
 		req.FetchSourceContext(
 			elastic.NewFetchSourceContext(true).Include(t.Include...).Exclude(t.Exclude...),
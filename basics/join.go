@@ -0,0 +1,92 @@
+package basics
+
+import (
+	"errors"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/olivere/elastic"
+	"strings"
+)
+
+// parseJoin 拆分 hasChild@type/hasParent@type/parentId@type, 没有@时join类型为空字符串
+func parseJoin(s string) (name, typ string) {
+	ss := strings.SplitN(s, "@", 2)
+	if len(ss) < 2 {
+		return s, ""
+	}
+	return ss[0], ss[1]
+}
+
+// isJoin nesting 是否为父子文档查询
+func isJoin(nesting string) bool {
+	return nesting == "hasChild" || nesting == "hasParent"
+}
+
+// checkJoin 检查 hasChild/hasParent/parentId 相关的tag
+func checkJoin(tags *esTags) error {
+	join := isJoin(tags.Nesting) || tags.Relational == "parentId"
+	if join && tags.Join == "" {
+		return errors.New("需要指定join类型, 如 hasChild@answer")
+	}
+	if !join && tags.Join != "" {
+		return errors.New("只有hasChild/hasParent/parentId可以指定join类型")
+	}
+	if (tags.MinChildren != nil || tags.MaxChildren != nil) && tags.Nesting != "hasChild" {
+		return errors.New("minChildren/maxChildren 只能用于hasChild")
+	}
+	if tags.MinChildren != nil && tags.MaxChildren != nil && *tags.MinChildren > *tags.MaxChildren {
+		return errors.New("minChildren 不能大于maxChildren")
+	}
+	return nil
+}
+
+// joinQuery 使用 has_child/has_parent 包装子/父文档的查询
+func (t *StructToEsQuery) joinQuery(q elastic.Query) elastic.Query {
+	tags := t.tags
+	if t.type_ == "hasParent" {
+		pq := elastic.NewHasParentQuery(tags.Join, q)
+		if tags.ScoreMode == "score" {
+			pq.Score(true)
+		}
+		if tags.IgnoreUnmapped {
+			pq.IgnoreUnmapped(true)
+		}
+		if ih := t.innerHit(); ih != nil {
+			pq.InnerHit(ih)
+		}
+		return pq
+	}
+	cq := elastic.NewHasChildQuery(tags.Join, q)
+	if tags.ScoreMode != "" {
+		cq.ScoreMode(tags.ScoreMode)
+	}
+	if tags.MinChildren != nil {
+		cq.MinChildren(*tags.MinChildren)
+	}
+	if tags.MaxChildren != nil {
+		cq.MaxChildren(*tags.MaxChildren)
+	}
+	if ih := t.innerHit(); ih != nil {
+		cq.InnerHit(ih)
+	}
+	return cq
+}
+
+// parentIdToQuery 查询父文档id为传入值的子文档, 传入多个值时满足其中一个即可
+func (t *StructToEsQuery) parentIdToQuery() []elastic.Query {
+	if len(t.val) == 1 {
+		return []elastic.Query{t.parentIdQuery(t.val[0])}
+	}
+	q := elastic.NewBoolQuery()
+	for _, v := range t.val {
+		q.Should(t.parentIdQuery(v))
+	}
+	return []elastic.Query{q}
+}
+
+func (t *StructToEsQuery) parentIdQuery(id interface{}) *elastic.ParentIdQuery {
+	q := elastic.NewParentIdQuery(t.tags.Join, jsoniter.Wrap(id).ToString())
+	if t.tags.IgnoreUnmapped {
+		q.IgnoreUnmapped(true)
+	}
+	return q
+}
@@ -0,0 +1,124 @@
+package basics
+
+import (
+	"errors"
+	"github.com/olivere/elastic"
+	"strings"
+)
+
+// checkMatch 检查 match 系列查询相关的tag
+func checkMatch(tags *esTags) error {
+	switch tags.MultiType {
+	case "", "best_fields", "most_fields", "cross_fields", "phrase", "phrase_prefix":
+	default:
+		return errors.New("multiType: " + tags.MultiType + " 不存在")
+	}
+	switch tags.ZeroTermsQuery {
+	case "", "none", "all":
+	default:
+		return errors.New("zeroTermsQuery 仅支持 none, all")
+	}
+	return nil
+}
+
+// newMatchQuery 生成 match/matchAnd/matchPhrase/matchPhrasePrefix 查询并设置tag中的参数
+// match_phrase/match_phrase_prefix 只支持 analyzer 和 boost
+func (t *StructToEsQuery) newMatchQuery(name string, val interface{}) elastic.Query {
+	tags := t.tags
+	switch t.relational {
+	case "matchPhrase":
+		q := elastic.NewMatchPhraseQuery(name, val)
+		if tags.Analyzer != "" {
+			q.Analyzer(tags.Analyzer)
+		}
+		if tags.Boost != nil {
+			q.Boost(*tags.Boost)
+		}
+		return q
+	case "matchPhrasePrefix":
+		q := elastic.NewMatchPhrasePrefixQuery(name, val)
+		if tags.Analyzer != "" {
+			q.Analyzer(tags.Analyzer)
+		}
+		if tags.Boost != nil {
+			q.Boost(*tags.Boost)
+		}
+		return q
+	}
+	q := elastic.NewMatchQuery(name, val)
+	if t.relational == "matchAnd" {
+		q.Operator("and")
+	}
+	if tags.Fuzziness != "" {
+		q.Fuzziness(tags.Fuzziness)
+	}
+	if tags.PrefixLength != nil {
+		q.PrefixLength(*tags.PrefixLength)
+	}
+	if tags.Analyzer != "" {
+		q.Analyzer(tags.Analyzer)
+	}
+	if tags.MinimumShouldMatch != "" {
+		q.MinimumShouldMatch(tags.MinimumShouldMatch)
+	}
+	if tags.Boost != nil {
+		q.Boost(*tags.Boost)
+	}
+	if tags.ZeroTermsQuery != "" {
+		q.ZeroTermsQuery(tags.ZeroTermsQuery)
+	}
+	if tags.Lenient {
+		q.Lenient(true)
+	}
+	return q
+}
+
+// multiMatchToQuery fields 中的所有字段合并为一个 multi_match 查询, 字段支持 ^ 设置权重, 如 title^3
+// 多个值时使用should连接
+func (t *StructToEsQuery) multiMatchToQuery() (query []elastic.Query) {
+	fields := make([]string, len(t.fields))
+	for i, name := range t.fields {
+		if t.parent != "" {
+			name = t.parent + "." + name
+		}
+		fields[i] = strings.TrimSpace(name)
+	}
+	if len(t.val) == 1 {
+		return []elastic.Query{t.newMultiMatchQuery(t.val[0], fields)}
+	}
+	q := elastic.NewBoolQuery()
+	for _, v := range t.val {
+		q.Should(t.newMultiMatchQuery(v, fields))
+	}
+	return []elastic.Query{q}
+}
+
+func (t *StructToEsQuery) newMultiMatchQuery(val interface{}, fields []string) *elastic.MultiMatchQuery {
+	tags := t.tags
+	q := elastic.NewMultiMatchQuery(val, fields...)
+	if tags.MultiType != "" {
+		q.Type(tags.MultiType)
+	}
+	if tags.Fuzziness != "" {
+		q.Fuzziness(tags.Fuzziness)
+	}
+	if tags.PrefixLength != nil {
+		q.PrefixLength(*tags.PrefixLength)
+	}
+	if tags.Analyzer != "" {
+		q.Analyzer(tags.Analyzer)
+	}
+	if tags.MinimumShouldMatch != "" {
+		q.MinimumShouldMatch(tags.MinimumShouldMatch)
+	}
+	if tags.Boost != nil {
+		q.Boost(*tags.Boost)
+	}
+	if tags.ZeroTermsQuery != "" {
+		q.ZeroTermsQuery(tags.ZeroTermsQuery)
+	}
+	if tags.Lenient {
+		q.Lenient(true)
+	}
+	return q
+}
@@ -0,0 +1,176 @@
+package basics
+
+import (
+	"errors"
+	"github.com/olivere/elastic"
+)
+
+// nestingScoreModes nested/hasChild/hasParent 支持的 scoreMode
+// hasParent 的 score 表示使用父文档的评分, none 表示不评分
+var nestingScoreModes = map[string]map[string]bool{
+	"nested":    {"none": true, "avg": true, "sum": true, "max": true, "min": true},
+	"hasChild":  {"none": true, "avg": true, "sum": true, "max": true, "min": true},
+	"hasParent": {"none": true, "score": true},
+}
+
+// checkNested 检查 nested/hasChild/hasParent 每一层的参数及 innerHits 的名称
+func checkNested(tags *esTags) error {
+	if tags.Score == "" && tags.ScoreMode != "" {
+		modes, ok := nestingScoreModes[tags.Nesting]
+		if !ok {
+			return errors.New("scoreMode 只能用于评分字段或nested/hasChild/hasParent")
+		}
+		if !modes[tags.ScoreMode] {
+			return errors.New("scoreMode: " + tags.ScoreMode + " 不能用于" + tags.Nesting)
+		}
+	}
+	if tags.IgnoreUnmapped && tags.Nesting != "nested" && tags.Nesting != "hasParent" && tags.Relational != "parentId" {
+		return errors.New("ignoreUnmapped 只能用于nested/hasParent/parentId")
+	}
+	if tags.Name != "" && tags.Nesting != "innerHits" {
+		return errors.New("name 只能用于innerHits")
+	}
+	return nil
+}
+
+// setInnerHits 将 innerHits 字段设置到所在的 nested/hasChild/hasParent 节点, 不在其中时忽略
+func (t *StructToEsQuery) setInnerHits(tags *esTags, val EsInnerHits) {
+	if t.scope == nil {
+		return
+	}
+	t.scope.innerHits = val
+	t.scope.innerName = tags.Name
+}
+
+// innerHit 生成当前节点的 inner_hits, 结构体中的排序字段作为 inner_hits 的排序, 高亮字段作为 inner_hits 的高亮
+func (t *StructToEsQuery) innerHit() *elastic.InnerHit {
+	if t.innerHits == nil {
+		return nil
+	}
+	res := t.innerHits.InitInnerHits()
+	if t.innerName != "" {
+		res.Name(t.innerName)
+		// 记录名称对应的路径, 解码时文档中没有同名字段时按路径查找
+		path := t.parent
+		if isJoin(t.type_) {
+			path = t.tags.Join
+		}
+		root := t.getRoot()
+		if root.innerPaths == nil {
+			root.innerPaths = make(map[string]string)
+		}
+		root.innerPaths[t.innerName] = path
+	}
+	if sorters := t.levelSorters(); len(sorters) > 0 {
+		res.SortBy(sorters...)
+	}
+	if hl := t.getHighlight(); hl != nil {
+		res.Highlight(hl)
+	}
+	return res
+}
+
+// nestedQuery 使用 nested 包装当前层的查询, 设置该层的 scoreMode/ignoreUnmapped/innerHits
+func (t *StructToEsQuery) nestedQuery(q elastic.Query) *elastic.NestedQuery {
+	nq := elastic.NewNestedQuery(t.parent, q)
+	if tags := t.tags; tags != nil {
+		if tags.ScoreMode != "" {
+			nq.ScoreMode(tags.ScoreMode)
+		}
+		if tags.IgnoreUnmapped {
+			nq.IgnoreUnmapped(true)
+		}
+	}
+	if ih := t.innerHit(); ih != nil {
+		nq.InnerHit(ih)
+	}
+	return nq
+}
+
+// checkInnerNames 检查结构体中 inner_hits 的名称是否重复, 同一个请求中es不允许重复的名称
+// 未指定name时es使用 nested 的完整路径或 join 类型作为名称; path 为结构体的字段路径, parent 为nested路径
+// names 记录已使用的名称及对应的字段路径, visiting 记录递归中的解析计划以支持自引用结构
+func checkInnerNames(plan *Plan, path, parent string, names map[string]string, visiting map[*Plan]bool) error {
+	if plan == nil || visiting[plan] {
+		return nil
+	}
+	visiting[plan] = true
+	defer delete(visiting, plan)
+	for _, f := range plan.fields {
+		tags := f.tags
+		fieldPath := path + "." + f.name
+		if f.anonymous || tags.Block {
+			if err := checkInnerNames(f.child, fieldPath, parent, names, visiting); err != nil {
+				return err
+			}
+			continue
+		}
+		p := parent
+		for _, key := range logicalNested(tags) {
+			p = joinPath(p, key)
+		}
+		if tags.Nesting == "innerHits" {
+			// 设置到逻辑运算中 nested@path 生成的nested层
+			if p != parent {
+				if err := addInnerName(names, f.tags, p, fieldPath, f.tag); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if tags.Sort != "" || !f.hasChild() {
+			continue
+		}
+		p = joinPath(p, f.names[0])
+		name := p
+		if isJoin(tags.Nesting) {
+			// 子/父文档的字段不需要添加前缀
+			p, name = "", tags.Join
+		}
+		if tags.Nesting != "obj" {
+			if ih := findInnerHits(f.child, make(map[*Plan]bool)); ih != nil {
+				if err := addInnerName(names, ih.tags, name, fieldPath, f.tag); err != nil {
+					return err
+				}
+			}
+		}
+		if err := checkInnerNames(f.child, fieldPath, p, names, visiting); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addInnerName 记录 innerHits 字段生成的 inner_hits 名称, 未指定name时使用默认名称
+// 名称重复时返回 *TagError, fieldPath/tag 为 nested/hasChild/hasParent 字段或使用 nested@path 的 innerHits 字段
+func addInnerName(names map[string]string, tags *esTags, name, fieldPath, tag string) error {
+	if tags.Name != "" {
+		name = tags.Name
+	}
+	if prev, ok := names[name]; ok {
+		reason := "inner_hits 名称 " + name + " 与 " + prev + " 重复, 需要在innerHits字段上使用name指定不同的名称"
+		return &TagError{Field: fieldPath, Tag: tag, Reason: reason}
+	}
+	names[name] = fieldPath
+	return nil
+}
+
+// findInnerHits 查找 nested/hasChild/hasParent 结构体中的 innerHits 字段, 包括匿名/block/obj 结构体中的字段
+// 逻辑运算中使用 nested@path 的 innerHits 字段属于其它nested层, 由 checkInnerNames 处理
+func findInnerHits(plan *Plan, visiting map[*Plan]bool) *planField {
+	if plan == nil || visiting[plan] {
+		return nil
+	}
+	visiting[plan] = true
+	for _, f := range plan.fields {
+		if f.tags.Nesting == "innerHits" && len(logicalNested(f.tags)) == 0 {
+			return f
+		}
+		if f.anonymous || f.tags.Block || f.tags.Nesting == "obj" {
+			if ih := findInnerHits(f.child, visiting); ih != nil {
+				return ih
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package basics
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/olivere/elastic"
+)
+
+type nestedTestC struct {
+	Z     *string   `json:"z" es:"match;highlight"`
+	Inner *EsSelect `json:"inner" es:"innerHits;name:c_hits"`
+}
+
+type nestedTestB struct {
+	Y     *int         `json:"y" es:"gte"`
+	SortY *int         `json:"sort_y" es:"sort" field:"y"`
+	C     *nestedTestC `json:"c" es:"nested;scoreMode:max;ignoreUnmapped"`
+	Inner *EsSelect    `json:"inner" es:"innerHits;name:b_hits"`
+}
+
+type nestedTestA struct {
+	X     *string      `json:"x" es:"must"`
+	B     *nestedTestB `json:"b" es:"nested;scoreMode:sum"`
+	Inner *EsSelect    `json:"inner" es:"innerHits"`
+}
+
+type nestedTestForm struct {
+	A *nestedTestA `json:"a" es:"nested;scoreMode:avg;ignoreUnmapped"`
+}
+
+func newNestedTestForm() *nestedTestForm {
+	x, y, sort, z := "x", 1, 1, "z"
+	return &nestedTestForm{A: &nestedTestA{
+		X:     &x,
+		Inner: &EsSelect{},
+		B: &nestedTestB{
+			Y:     &y,
+			SortY: &sort,
+			Inner: &EsSelect{Size: 5},
+			C:     &nestedTestC{Z: &z, Inner: &EsSelect{}},
+		},
+	}}
+}
+
+// querySource 将查询转换为 map, 便于按json结构检查
+func querySource(t *testing.T, q elastic.Query) map[string]interface{} {
+	t.Helper()
+	src, err := q.Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err = json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// findNested 在查询中查找 path 对应的 nested 查询
+func findNested(v interface{}, path string) map[string]interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if nested, ok := v["nested"].(map[string]interface{}); ok && nested["path"] == path {
+			return nested
+		}
+		for _, item := range v {
+			if res := findNested(item, path); res != nil {
+				return res
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if res := findNested(item, path); res != nil {
+				return res
+			}
+		}
+	}
+	return nil
+}
+
+func TestNestedLevels(t *testing.T) {
+	q, err := NewStructToEsQuery().ToQueryE(newNestedTestForm())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := querySource(t, q)
+	levels := []struct {
+		path           string
+		scoreMode      string
+		ignoreUnmapped bool
+		innerName      string
+	}{
+		{path: "a", scoreMode: "avg", ignoreUnmapped: true},
+		{path: "a.b", scoreMode: "sum", innerName: "b_hits"},
+		{path: "a.b.c", scoreMode: "max", ignoreUnmapped: true, innerName: "c_hits"},
+	}
+	for _, level := range levels {
+		nested := findNested(m, level.path)
+		if nested == nil {
+			t.Fatalf("%s: nested 查询不存在", level.path)
+		}
+		if nested["score_mode"] != level.scoreMode {
+			t.Errorf("%s: score_mode = %v, want %s", level.path, nested["score_mode"], level.scoreMode)
+		}
+		if _, ok := nested["ignore_unmapped"]; ok != level.ignoreUnmapped {
+			t.Errorf("%s: ignore_unmapped = %v, want %v", level.path, nested["ignore_unmapped"], level.ignoreUnmapped)
+		}
+		inner, ok := nested["inner_hits"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("%s: inner_hits 不存在", level.path)
+		}
+		if name, _ := inner["name"].(string); name != level.innerName {
+			t.Errorf("%s: inner_hits name = %q, want %q", level.path, name, level.innerName)
+		}
+	}
+
+	inner := findNested(m, "a.b")["inner_hits"].(map[string]interface{})
+	if inner["size"] != float64(5) {
+		t.Errorf("a.b: inner_hits size = %v, want 5", inner["size"])
+	}
+	sorts, _ := inner["sort"].([]interface{})
+	if len(sorts) != 1 {
+		t.Fatalf("a.b: inner_hits sort = %v, want a.b.y", inner["sort"])
+	}
+	if _, ok := sorts[0].(map[string]interface{})["a.b.y"]; !ok {
+		t.Errorf("a.b: inner_hits sort = %v, want a.b.y", sorts[0])
+	}
+	if _, ok := inner["highlight"]; ok {
+		t.Errorf("a.b: inner_hits highlight = %v, want none", inner["highlight"])
+	}
+
+	inner = findNested(m, "a.b.c")["inner_hits"].(map[string]interface{})
+	highlight, _ := inner["highlight"].(map[string]interface{})
+	fields, _ := highlight["fields"].(map[string]interface{})
+	if _, ok := fields["a.b.c.z"]; !ok || len(fields) != 1 {
+		t.Errorf("a.b.c: inner_hits highlight = %v, want a.b.c.z", inner["highlight"])
+	}
+	if _, ok := inner["sort"]; ok {
+		t.Errorf("a.b.c: inner_hits sort = %v, want none", inner["sort"])
+	}
+}
+
+func TestNestedInnerHitsHighlightOff(t *testing.T) {
+	form := newNestedTestForm()
+	off := false
+	form.A.B.C.Inner.Highlight = &off
+	q, err := NewStructToEsQuery().ToQueryE(form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner := findNested(querySource(t, q), "a.b.c")["inner_hits"].(map[string]interface{})
+	if _, ok := inner["highlight"]; ok {
+		t.Errorf("a.b.c: inner_hits highlight = %v, want none", inner["highlight"])
+	}
+}
+
+func TestNestedRepeatedQuery(t *testing.T) {
+	// 同一个对象多次解析时不保留上一次的状态
+	query := NewStructToEsQuery()
+	for i := 0; i < 2; i++ {
+		body, err := query.ToSearchBodyE(newNestedTestForm())
+		if err != nil {
+			t.Fatalf("第%d次解析: %v", i+1, err)
+		}
+		if len(body.Sorter) != 0 {
+			t.Errorf("第%d次解析: sorters = %d, want 0", i+1, len(body.Sorter))
+		}
+		inner := findNested(querySource(t, body.Query), "a.b")["inner_hits"].(map[string]interface{})
+		if sorts, _ := inner["sort"].([]interface{}); len(sorts) != 1 {
+			t.Errorf("第%d次解析: a.b inner_hits sort = %v, want 1", i+1, inner["sort"])
+		}
+	}
+}
+
+func TestNestedDecodeNamedInnerHits(t *testing.T) {
+	query := NewStructToEsQuery()
+	if _, err := query.ToQueryE(newNestedTestForm()); err != nil {
+		t.Fatal(err)
+	}
+	type cDoc struct {
+		Z string `json:"z"`
+	}
+	type bDoc struct {
+		Y int    `json:"y"`
+		C []cDoc `json:"c"`
+	}
+	type aDoc struct {
+		X string `json:"x"`
+		B []bDoc `json:"b"`
+	}
+	type doc struct {
+		A []aDoc `json:"a"`
+	}
+	raw := `{"hits":[{"_id":"1","_source":{},"inner_hits":{"a":{"hits":{"hits":[{"_source":{"x":"x"},"inner_hits":{
+		"b_hits":{"hits":{"hits":[{"_source":{"y":1},"inner_hits":{
+			"c_hits":{"hits":{"hits":[{"_source":{"z":"z"}}]}}}}]}}}}]}}}}]}`
+	var hits elastic.SearchHits
+	if err := json.Unmarshal([]byte(raw), &hits); err != nil {
+		t.Fatal(err)
+	}
+	var docs []doc
+	if _, err := decodeSearchHits(&hits, &docs, query.innerPaths); err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || len(docs[0].A) != 1 || len(docs[0].A[0].B) != 1 || len(docs[0].A[0].B[0].C) != 1 {
+		t.Fatalf("docs = %+v", docs)
+	}
+	if z := docs[0].A[0].B[0].C[0].Z; z != "z" {
+		t.Errorf("a.b.c.z = %q, want z", z)
+	}
+}
+
+func TestNestedInnerHitsNameCollision(t *testing.T) {
+	type defaultName struct {
+		A      *nestedTestA `json:"a" es:"nested"`
+		AInner *EsSelect    `json:"a_inner" es:"logical:should,nested@a;innerHits"`
+	}
+	type sameName struct {
+		A  *nestedTestA `json:"a" es:"nested"`
+		A2 *nestedTestC `json:"a2" es:"nested"`
+	}
+	type distinctName struct {
+		A      *nestedTestA `json:"a" es:"nested"`
+		AInner *EsSelect    `json:"a_inner" es:"logical:should,nested@a;innerHits;name:a_should"`
+	}
+	tests := []struct {
+		form  interface{}
+		field string
+	}{
+		{form: defaultName{}, field: "defaultName.AInner"},
+		{form: sameName{}, field: "sameName.A2"},
+		{form: distinctName{}},
+	}
+	for _, tt := range tests {
+		err := Validate(tt.form)
+		if tt.field == "" {
+			if err != nil {
+				t.Errorf("%T: %v", tt.form, err)
+			}
+			continue
+		}
+		var tagErr *TagError
+		if !errors.As(err, &tagErr) {
+			t.Errorf("%T: err = %v, want *TagError", tt.form, err)
+			continue
+		}
+		if tagErr.Field != tt.field {
+			t.Errorf("%T: field = %s, want %s", tt.form, tagErr.Field, tt.field)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package basics
+
+import (
+	"fmt"
+	"github.com/olivere/elastic"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Plan 结构体的解析计划, 由 Compile 一次性解析所有 es/field/fields/json tag 生成
+// Plan 创建后不再修改, 可在多个 goroutine 之间共享
+type Plan struct {
+	typ    reflect.Type
+	fields []*planField
+}
+
+type planField struct {
+	index     int
+	name      string   // 结构体字段名
+	key       string   // json名称, 未设置时为字段名, 用作聚合名称
+	tag       string   // es tag 原文, 用于 TagError
+	names     []string // es中对应的字段名, 优先级 fields > field > json > 字段名
+	anonymous bool
+	isRange   bool // 字段类型为 RangeInt/RangeInt64/RangeFloat/RangeTime
+	tags      *esTags
+	child     *Plan // 匿名/block/nested/obj/hasChild/hasParent/sort:nested 字段对应结构体的解析计划
+}
+
+var planCache sync.Map // map[reflect.Type]*Plan
+
+// Compile 解析结构体类型生成解析计划, 结果按类型缓存, tag错误时返回 *TagError
+func Compile(typ reflect.Type) (*Plan, error) {
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("仅支持解析结构体, 传入类型为: %v", typ)
+	}
+	if plan, ok := planCache.Load(typ); ok {
+		return plan.(*Plan), nil
+	}
+	plan, err := compile(typ, typ.Name(), make(map[reflect.Type]*Plan))
+	if err != nil {
+		return nil, err
+	}
+	if err = checkInnerNames(plan, typ.Name(), "", make(map[string]string), make(map[*Plan]bool)); err != nil {
+		return nil, err
+	}
+	if err = checkAggs(plan, typ.Name(), make(map[string]string), false, make(map[*Plan]bool)); err != nil {
+		return nil, err
+	}
+	res, _ := planCache.LoadOrStore(typ, plan)
+	return res.(*Plan), nil
+}
+
+// MustCompile 同 Compile, 传入结构体或结构体指针, 解析失败时panic
+// 一般用于包初始化: var formPlan = basics.MustCompile(Form{})
+func MustCompile(form interface{}) *Plan {
+	plan, err := Compile(reflect.TypeOf(form))
+	if err != nil {
+		panic(err)
+	}
+	return plan
+}
+
+// compile 递归解析结构体, path 为当前结构体的字段路径, building 记录解析中的类型以支持自引用结构
+func compile(typ reflect.Type, path string, building map[reflect.Type]*Plan) (*Plan, error) {
+	if plan, ok := planCache.Load(typ); ok {
+		return plan.(*Plan), nil
+	}
+	if plan := building[typ]; plan != nil {
+		return plan, nil
+	}
+	plan := &Plan{typ: typ}
+	building[typ] = plan
+	tmp := new(StructToEsQuery)
+	for i := 0; i < typ.NumField(); i++ {
+		tt := typ.Field(i)
+		tag := tt.Tag.Get("es")
+		tags, err := tmp.getTags(tag)
+		if err == nil && tags != nil {
+			err = checkTags(tags)
+		}
+		if err != nil {
+			return nil, &TagError{Field: path + "." + tt.Name, Tag: tag, Reason: err.Error()}
+		}
+		if tags == nil {
+			continue
+		}
+		field := &planField{
+			index:     i,
+			name:      tt.Name,
+			key:       getJsonName(tt),
+			tag:       tag,
+			names:     tmp.getNames(tt.Name, tt.Tag),
+			anonymous: tt.Anonymous,
+			isRange:   isRangeType(tt.Type),
+			tags:      tags,
+		}
+		if reason := field.checkRange(); reason != "" {
+			return nil, &TagError{Field: path + "." + tt.Name, Tag: tag, Reason: reason}
+		}
+		if reason := checkGeoField(tt.Type, tags); reason != "" {
+			return nil, &TagError{Field: path + "." + tt.Name, Tag: tag, Reason: reason}
+		}
+		if field.hasChild() {
+			ft := tt.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() != reflect.Struct {
+				return nil, &TagError{Field: path + "." + tt.Name, Tag: tag, Reason: "字段类型必须为结构体"}
+			}
+			child, err := compile(ft, path+"."+tt.Name, building)
+			if err != nil {
+				return nil, err
+			}
+			field.child = child
+		}
+		plan.fields = append(plan.fields, field)
+	}
+	return plan, nil
+}
+
+func getJsonName(tt reflect.StructField) string {
+	name := strings.Split(tt.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return tt.Name
+	}
+	return name
+}
+
+// checkRange 检查范围类型和 overlap 字段, 返回错误原因
+func (t *planField) checkRange() string {
+	tags := t.tags
+	if t.isRange && tags.Relational != "" && tags.Relational != "range" && tags.Relational != "overlap" {
+		return "范围类型只能使用range或overlap"
+	}
+	if !t.isRange && tags.Relational == "" && tags.Relation != "" {
+		return "relation 只能用于range系列查询"
+	}
+	if tags.Relational == "overlap" && len(t.names) != 2 {
+		return "overlap 需要在fields中指定开始和结束两个字段"
+	}
+	return ""
+}
+
+// hasChild 字段是否需要按结构体继续解析
+func (t *planField) hasChild() bool {
+	if t.anonymous || t.tags.Block {
+		return t.tags.Nesting != "innerHits"
+	}
+	if t.tags.Sort != "" {
+		return t.tags.Sort == "nested"
+	}
+	return t.tags.Nesting == "nested" || t.tags.Nesting == "obj" || isJoin(t.tags.Nesting)
+}
+
+// NewQuery 创建使用该计划的查询对象, StructToEsQuery 有状态, 不能并发使用, 每次请求应创建新的对象
+func (p *Plan) NewQuery() *StructToEsQuery {
+	res := NewStructToEsQuery()
+	res.plan = p
+	return res
+}
+
+// ToQuery 同 StructToEsQuery.ToQuery, 可并发调用
+func (p *Plan) ToQuery(form interface{}) *elastic.BoolQuery {
+	return p.NewQuery().ToQuery(form)
+}
+
+// ToSearchBody 同 StructToEsQuery.ToSearchBody, 可并发调用
+func (p *Plan) ToSearchBody(form interface{}) *SearchBody {
+	return p.NewQuery().ToSearchBody(form)
+}
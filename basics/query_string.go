@@ -0,0 +1,186 @@
+package basics
+
+import (
+	"errors"
+	"fmt"
+	"github.com/olivere/elastic"
+	"strings"
+)
+
+// simpleQueryStringFlags simple_query_string 支持的flags
+var simpleQueryStringFlags = map[string]bool{
+	"ALL": true, "NONE": true, "AND": true, "OR": true, "NOT": true, "PREFIX": true, "PHRASE": true,
+	"PRECEDENCE": true, "ESCAPE": true, "WHITESPACE": true, "FUZZY": true, "NEAR": true, "SLOP": true,
+}
+
+// queryStringSyntax query_string 的语法分组及对应的保留字符
+var queryStringSyntax = map[string]string{
+	"bool":     "+-!&|",
+	"group":    "()",
+	"phrase":   `"`,
+	"wildcard": "*?",
+	"range":    "[]{}<>=",
+	"fuzzy":    "~",
+	"boost":    "^",
+	"field":    ":",
+	"regexp":   "/",
+}
+
+// queryStringDefaultAllow queryString 未设置allow时允许的语法
+var queryStringDefaultAllow = []string{"bool", "group", "phrase", "range", "field"}
+
+// checkQueryString 检查 simpleQueryString/queryString 相关的tag
+func checkQueryString(tags *esTags) error {
+	if tags.Relational == "simpleQueryString" && tags.Flags != "" {
+		for _, flag := range strings.Split(tags.Flags, "|") {
+			if !simpleQueryStringFlags[flag] {
+				return errors.New("flags: " + flag + " 不存在")
+			}
+		}
+	}
+	for _, v := range tags.Allow {
+		if _, ok := queryStringSyntax[v]; !ok {
+			return errors.New("allow: " + v + " 不存在")
+		}
+	}
+	return nil
+}
+
+// queryStringFields 查询字段, 用于限制用户输入中可以使用的字段
+func (t *StructToEsQuery) queryStringFields() (fields []string, allow map[string]bool) {
+	allow = make(map[string]bool)
+	for _, name := range t.fields {
+		name = strings.TrimSpace(name)
+		short := strings.Split(name, "^")[0]
+		allow[short] = true
+		if t.parent != "" {
+			name = t.parent + "." + name
+			allow[t.parent+"."+short] = true
+		}
+		fields = append(fields, name)
+	}
+	return
+}
+
+// queryStringToQuery 生成 simple_query_string/query_string 查询, 只查询fields中的字段
+// 多个值时使用should连接
+func (t *StructToEsQuery) queryStringToQuery() (query []elastic.Query) {
+	fields, allow := t.queryStringFields()
+	qs := make([]elastic.Query, 0, len(t.val))
+	for _, v := range t.val {
+		text := fmt.Sprint(v)
+		if t.relational == "simpleQueryString" {
+			if t.tags.Sanitize {
+				text = sanitizeQueryString(text, "+|-\"*()~\\")
+			}
+			q := elastic.NewSimpleQueryStringQuery(text)
+			for _, field := range fields {
+				q.Field(field)
+			}
+			if t.tags.Flags != "" {
+				q.Flags(t.tags.Flags)
+			}
+			qs = append(qs, q)
+			continue
+		}
+		text = t.sanitizeQueryString(text, allow)
+		q := elastic.NewQueryStringQuery(text).AllowLeadingWildcard(false)
+		for _, field := range fields {
+			q.Field(field)
+		}
+		qs = append(qs, q)
+	}
+	if len(qs) == 1 {
+		return qs
+	}
+	return []elastic.Query{elastic.NewBoolQuery().Should(qs...)}
+}
+
+// sanitizeQueryString 转义 reserved 中的字符
+func sanitizeQueryString(text, reserved string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if strings.ContainsRune(reserved, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// sanitizeQueryString 处理 query_string 的用户输入
+//   - 未允许的语法对应的保留字符被转义, < > 无法转义直接删除
+//   - 未允许bool时 AND/OR/NOT 转为小写, 未允许range时 TO 转为小写, 作为普通词查询
+//   - 允许field时只能使用fields中的字段, 其它字段的 : 被转义
+//   - sanitize 时转义所有保留字符
+func (t *StructToEsQuery) sanitizeQueryString(text string, fields map[string]bool) string {
+	allowed := make(map[rune]bool)
+	allow := t.tags.Allow
+	if allow == nil {
+		allow = queryStringDefaultAllow
+	}
+	syntax := make(map[string]bool)
+	if !t.tags.Sanitize {
+		for _, v := range allow {
+			syntax[v] = true
+			for _, r := range queryStringSyntax[v] {
+				allowed[r] = true
+			}
+		}
+	}
+	var b strings.Builder
+	word := make([]rune, 0)
+	flush := func() {
+		w := string(word)
+		switch w {
+		case "AND", "OR", "NOT":
+			if !syntax["bool"] {
+				w = strings.ToLower(w)
+			}
+		case "TO":
+			if !syntax["range"] {
+				w = strings.ToLower(w)
+			}
+		}
+		b.WriteString(w)
+		word = word[:0]
+	}
+	for _, r := range text {
+		if r == '\\' {
+			flush()
+			b.WriteString(`\\`)
+			continue
+		}
+		reserved := false
+		for _, chars := range queryStringSyntax {
+			if strings.ContainsRune(chars, r) {
+				reserved = true
+				break
+			}
+		}
+		if !reserved {
+			if r == ' ' || r == '\t' || r == '\n' {
+				flush()
+				b.WriteRune(r)
+			} else {
+				word = append(word, r)
+			}
+			continue
+		}
+		if r == ':' && allowed[r] && !fields[string(word)] {
+			// 不允许查询的字段
+			flush()
+			b.WriteString(`\:`)
+			continue
+		}
+		flush()
+		if allowed[r] {
+			b.WriteRune(r)
+		} else if r != '<' && r != '>' {
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		}
+	}
+	flush()
+	return b.String()
+}
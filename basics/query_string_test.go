@@ -0,0 +1,35 @@
+package basics
+
+import "testing"
+
+func TestSanitizeQueryString(t *testing.T) {
+	fields := map[string]bool{"title": true}
+	tests := []struct {
+		name     string
+		allow    []string
+		sanitize bool
+		text     string
+		want     string
+	}{
+		{name: "允许的字段", text: "title:x", want: "title:x"},
+		{name: "不允许的字段", text: "secret:x", want: `secret\:x`},
+		{name: "_exists_", text: "_exists_:title", want: `_exists_\:title`},
+		{name: "开头的通配符", text: "*abc", want: `\*abc`},
+		{name: "允许wildcard", allow: []string{"wildcard"}, text: "ab*", want: "ab*"},
+		{name: "正则", text: "/ab.*/", want: `\/ab.\*\/`},
+		{name: "转义的冒号", text: `secret\:x`, want: `secret\\\:x`},
+		{name: "允许range时的TO", text: "[a TO b]", want: "[a TO b]"},
+		{name: "未允许range时的TO", allow: []string{"bool"}, text: "[a TO b]", want: `\[a to b\]`},
+		{name: "未允许range时的<>", allow: []string{"bool"}, text: "a>=b", want: `a\=b`},
+		{name: "允许bool时的AND", text: "a AND b", want: "a AND b"},
+		{name: "未允许bool时的AND", allow: []string{"range"}, text: "a AND b OR NOT c", want: "a and b or not c"},
+		{name: "未允许bool时的保留字符", allow: []string{"range"}, text: "+a -b", want: `\+a \-b`},
+		{name: "sanitize", sanitize: true, text: "title:x AND (y)", want: `title\:x and \(y\)`},
+	}
+	for _, tt := range tests {
+		q := &StructToEsQuery{tags: &esTags{Allow: tt.allow, Sanitize: tt.sanitize}}
+		if got := q.sanitizeQueryString(tt.text, fields); got != tt.want {
+			t.Errorf("%s: sanitizeQueryString(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
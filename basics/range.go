@@ -0,0 +1,272 @@
+package basics
+
+import (
+	"errors"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/olivere/elastic"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// RangeInt RangeInt64 RangeFloat RangeTime 明确指定边界的范围, 未设置的边界不限制, 可以解析:
+//   - 数组 [a,b], 对应 gte a 和 lte b, 空字符串或null表示不限制
+//   - 字符串 "a,b", 同数组
+//   - 对象 {"gt":a,"gte":a,"lt":b,"lte":b}
+//
+// 数字也可以是数字字符串
+type RangeInt struct {
+	Gt, Gte, Lt, Lte *int
+}
+
+type RangeInt64 struct {
+	Gt, Gte, Lt, Lte *int64
+}
+
+type RangeFloat struct {
+	Gt, Gte, Lt, Lte *float64
+}
+
+// RangeTime 时间可以是 RFC3339, "2006-01-02 15:04:05", "2006-01-02" 格式, 后两种按本地时区解析
+// 查询时转换为带时区的 RFC3339 格式, 精确到毫秒
+type RangeTime struct {
+	Gt, Gte, Lt, Lte *time.Time
+}
+
+// esRange 明确指定边界的范围, 未设置的边界返回nil
+type esRange interface {
+	rangeBounds() (gt, gte, lt, lte interface{})
+}
+
+var esRangeType = reflect.TypeOf((*esRange)(nil)).Elem()
+
+// rangeTimeLayouts RangeTime 支持的时间格式
+var rangeTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"}
+
+// rangeOps 边界名称, 下标与 set 的 i 对应
+var rangeOps = map[string]int{"gt": 0, "gte": 1, "lt": 2, "lte": 3}
+
+// unmarshalRange 解析范围, set 设置第i个边界(gt/gte/lt/lte)去掉引号后的值
+func unmarshalRange(b []byte, set func(i int, raw string) error) error {
+	s := strings.TrimSpace(string(b))
+	if s == "null" || s == `""` {
+		return nil
+	}
+	if s[0] == '{' {
+		var m map[string]jsoniter.RawMessage
+		if err := jsoniter.Unmarshal(b, &m); err != nil {
+			return err
+		}
+		for op, raw := range m {
+			i, ok := rangeOps[op]
+			if !ok {
+				return errors.New("范围不支持: " + op)
+			}
+			if err := setRangeBound(set, i, string(raw)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var vals []string
+	if s[0] == '[' {
+		var raws []jsoniter.RawMessage
+		if err := jsoniter.Unmarshal(b, &raws); err != nil {
+			return err
+		}
+		for _, raw := range raws {
+			vals = append(vals, string(raw))
+		}
+	} else {
+		vals = strings.Split(strings.Trim(s, `"`), ",")
+	}
+	if len(vals) > 2 {
+		return errors.New("范围最多只能有两个值")
+	}
+	for i, v := range vals {
+		// [a,b] 对应 gte 和 lte
+		if err := setRangeBound(set, 1+i*2, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setRangeBound(set func(i int, raw string) error, i int, raw string) error {
+	raw = strings.Trim(strings.TrimSpace(raw), `"`)
+	if raw == "" || raw == "null" {
+		return nil
+	}
+	return set(i, raw)
+}
+
+// rangeBound 未设置时返回nil, 避免返回包含nil指针的interface
+func rangeBound(v reflect.Value) interface{} {
+	if v.IsNil() {
+		return nil
+	}
+	return v.Elem().Interface()
+}
+
+func rangeBounds(t interface{}) (gt, gte, lt, lte interface{}) {
+	v := reflect.ValueOf(t)
+	return rangeBound(v.Field(0)), rangeBound(v.Field(1)), rangeBound(v.Field(2)), rangeBound(v.Field(3))
+}
+
+func (t *RangeInt) UnmarshalJSON(b []byte) error {
+	return unmarshalRange(b, func(i int, raw string) error {
+		v := new(int)
+		if err := jsoniter.UnmarshalFromString(raw, v); err != nil {
+			return err
+		}
+		*[]**int{&t.Gt, &t.Gte, &t.Lt, &t.Lte}[i] = v
+		return nil
+	})
+}
+
+func (t *RangeInt64) UnmarshalJSON(b []byte) error {
+	return unmarshalRange(b, func(i int, raw string) error {
+		v := new(int64)
+		if err := jsoniter.UnmarshalFromString(raw, v); err != nil {
+			return err
+		}
+		*[]**int64{&t.Gt, &t.Gte, &t.Lt, &t.Lte}[i] = v
+		return nil
+	})
+}
+
+func (t *RangeFloat) UnmarshalJSON(b []byte) error {
+	return unmarshalRange(b, func(i int, raw string) error {
+		v := new(float64)
+		if err := jsoniter.UnmarshalFromString(raw, v); err != nil {
+			return err
+		}
+		*[]**float64{&t.Gt, &t.Gte, &t.Lt, &t.Lte}[i] = v
+		return nil
+	})
+}
+
+func (t *RangeTime) UnmarshalJSON(b []byte) error {
+	return unmarshalRange(b, func(i int, raw string) error {
+		for _, layout := range rangeTimeLayouts {
+			if v, err := time.ParseInLocation(layout, raw, time.Local); err == nil {
+				*[]**time.Time{&t.Gt, &t.Gte, &t.Lt, &t.Lte}[i] = &v
+				return nil
+			}
+		}
+		return errors.New("时间格式错误: " + raw)
+	})
+}
+
+func (t RangeInt) rangeBounds() (gt, gte, lt, lte interface{})   { return rangeBounds(t) }
+func (t RangeInt64) rangeBounds() (gt, gte, lt, lte interface{}) { return rangeBounds(t) }
+func (t RangeFloat) rangeBounds() (gt, gte, lt, lte interface{}) { return rangeBounds(t) }
+
+func (t RangeTime) rangeBounds() (gt, gte, lt, lte interface{}) {
+	res := make([]interface{}, 4)
+	for i, v := range []*time.Time{t.Gt, t.Gte, t.Lt, t.Lte} {
+		if v != nil {
+			res[i] = v.Format("2006-01-02T15:04:05.000Z07:00")
+		}
+	}
+	return res[0], res[1], res[2], res[3]
+}
+
+// isRangeType 字段类型是否为 RangeInt/RangeInt64/RangeFloat/RangeTime
+func isRangeType(typ reflect.Type) bool {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ.Implements(esRangeType)
+}
+
+// getRange 读取范围, nil指针或没有设置任何边界时返回nil
+func (t *StructToEsQuery) getRange(v reflect.Value) []interface{} {
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Invalid || v.IsZero() {
+		return nil
+	}
+	return []interface{}{v.Interface()}
+}
+
+// rangeRelationals 可以使用relation的 relational, 空字符串对应范围类型的字段
+var rangeRelationals = map[string]bool{
+	"": true, "range": true, "rangeLte": true, "rangeIgnore0": true, "rangeLteIgnore0": true,
+	"lt": true, "lte": true, "gt": true, "gte": true, "dateRange": true,
+}
+
+// checkRange 检查 relation 相关的tag
+func checkRange(tags *esTags) error {
+	switch tags.Relation {
+	case "", "INTERSECTS", "WITHIN", "CONTAINS":
+	default:
+		return errors.New("relation: " + tags.Relation + " 不存在")
+	}
+	if tags.Relation != "" && !rangeRelationals[tags.Relational] {
+		return errors.New("relation 只能用于range系列查询")
+	}
+	return nil
+}
+
+// newRangeQuery 创建 range 查询, 设置了relation时查询 integer_range/date_range 等范围类型的字段
+func (t *StructToEsQuery) newRangeQuery(name string) *elastic.RangeQuery {
+	q := elastic.NewRangeQuery(name)
+	if t.tags.Relation != "" {
+		q.Relation(t.tags.Relation)
+	}
+	return q
+}
+
+// rangeQuery 按范围设置的边界生成 range 查询
+func (t *StructToEsQuery) rangeQuery(name string, r esRange) *elastic.RangeQuery {
+	q := t.newRangeQuery(name)
+	gt, gte, lt, lte := r.rangeBounds()
+	if gt != nil {
+		q.Gt(gt)
+	}
+	if gte != nil {
+		q.Gte(gte)
+	}
+	if lt != nil {
+		q.Lt(lt)
+	}
+	if lte != nil {
+		q.Lte(lte)
+	}
+	return q
+}
+
+// overlapToQuery 查询 [开始字段, 结束字段] 与传入的 [from, to] 有交集的文档
+// 即 开始字段 <= to 且 结束字段 >= from, 传入范围类型时 gt/lt 对应不包含边界
+func (t *StructToEsQuery) overlapToQuery() []elastic.Query {
+	start, end := t.fields[0], t.fields[1]
+	if t.parent != "" {
+		start, end = t.parent+"."+start, t.parent+"."+end
+	}
+	var gt, gte, lt, lte interface{}
+	if r, ok := t.val[0].(esRange); ok {
+		gt, gte, lt, lte = r.rangeBounds()
+	} else {
+		gte = t.val[0]
+		if len(t.val) > 1 {
+			lte = t.val[1]
+		}
+	}
+	var querys []elastic.Query
+	if lt != nil {
+		querys = append(querys, t.newRangeQuery(start).Lt(lt))
+	} else if lte != nil && lte != "" {
+		querys = append(querys, t.newRangeQuery(start).Lte(lte))
+	}
+	if gt != nil {
+		querys = append(querys, t.newRangeQuery(end).Gt(gt))
+	} else if gte != nil && gte != "" {
+		querys = append(querys, t.newRangeQuery(end).Gte(gte))
+	}
+	if len(querys) < 2 {
+		return querys
+	}
+	return []elastic.Query{elastic.NewBoolQuery().Filter(querys...)}
+}
@@ -0,0 +1,84 @@
+package basics
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRangeUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		raw              string
+		gt, gte, lt, lte interface{}
+		wantErr          bool
+	}{
+		{raw: `[1,10]`, gte: 1, lte: 10},
+		{raw: `["1","10"]`, gte: 1, lte: 10},
+		{raw: `"1,10"`, gte: 1, lte: 10},
+		{raw: `",10"`, lte: 10},
+		{raw: `[null,10]`, lte: 10},
+		{raw: `["",10]`, lte: 10},
+		{raw: `[1]`, gte: 1},
+		{raw: `{"gt":1,"lt":"10"}`, gt: 1, lt: 10},
+		{raw: `{"gte":1,"lte":null}`, gte: 1},
+		{raw: `null`},
+		{raw: `""`},
+		{raw: `[1,2,3]`, wantErr: true},
+		{raw: `{"eq":1}`, wantErr: true},
+		{raw: `["a"]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		var r RangeInt
+		err := json.Unmarshal([]byte(tt.raw), &r)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s 应返回错误", tt.raw)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.raw, err)
+			continue
+		}
+		gt, gte, lt, lte := r.rangeBounds()
+		if gt != tt.gt || gte != tt.gte || lt != tt.lt || lte != tt.lte {
+			t.Errorf("%s = %v %v %v %v, want %v %v %v %v", tt.raw, gt, gte, lt, lte, tt.gt, tt.gte, tt.lt, tt.lte)
+		}
+	}
+}
+
+func TestRangeTypesUnmarshalJSON(t *testing.T) {
+	var i64 RangeInt64
+	if err := json.Unmarshal([]byte(`[9007199254740993,""]`), &i64); err != nil {
+		t.Fatal(err)
+	}
+	if i64.Gte == nil || *i64.Gte != 9007199254740993 || i64.Lte != nil {
+		t.Errorf("RangeInt64 = %v %v, want 9007199254740993 nil", i64.Gte, i64.Lte)
+	}
+
+	var f RangeFloat
+	if err := json.Unmarshal([]byte(`"0.5,1.5"`), &f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Gte == nil || *f.Gte != 0.5 || f.Lte == nil || *f.Lte != 1.5 {
+		t.Errorf("RangeFloat = %v %v, want 0.5 1.5", f.Gte, f.Lte)
+	}
+
+	var rt RangeTime
+	if err := json.Unmarshal([]byte(`{"gte":"2022-01-02","lt":"2022-01-02 10:00:00","lte":"2022-01-02T10:00:00+08:00"}`), &rt); err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Time{
+		time.Date(2022, 1, 2, 0, 0, 0, 0, time.Local),
+		time.Date(2022, 1, 2, 10, 0, 0, 0, time.Local),
+		time.Date(2022, 1, 2, 10, 0, 0, 0, time.FixedZone("", 8*3600)),
+	}
+	for i, v := range []*time.Time{rt.Gte, rt.Lt, rt.Lte} {
+		if v == nil || !v.Equal(want[i]) {
+			t.Errorf("RangeTime[%d] = %v, want %v", i, v, want[i])
+		}
+	}
+	if err := json.Unmarshal([]byte(`["2022/01/02"]`), &rt); err == nil {
+		t.Error("RangeTime: 2022/01/02 应返回错误")
+	}
+}
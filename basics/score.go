@@ -0,0 +1,249 @@
+package basics
+
+import (
+	"errors"
+	"github.com/olivere/elastic"
+	"reflect"
+	"strconv"
+)
+
+// scoreTypes 支持的评分方式
+//   - gauss/exp/linear 衰减函数, 传入的值作为原点, 未传入时使用tag中的origin
+//   - fieldValueFactor 按字段的值评分, 传入true或非零值时生效
+//   - weight 字段生成的查询作为过滤条件, 匹配的文档乘以weight
+//   - random 随机评分, 传入的值作为种子
+//   - negative 字段生成的查询作为 boosting 查询的negative, 匹配的文档乘以negativeBoost
+var scoreTypes = map[string]bool{
+	"gauss": true, "exp": true, "linear": true, "fieldValueFactor": true, "weight": true, "random": true, "negative": true,
+}
+
+var scoreModifiers = map[string]bool{
+	"none": true, "log": true, "log1p": true, "log2p": true, "ln": true, "ln1p": true, "ln2p": true,
+	"square": true, "sqrt": true, "reciprocal": true,
+}
+
+var scoreModes = map[string]bool{"multiply": true, "sum": true, "avg": true, "first": true, "max": true, "min": true}
+
+var boostModes = map[string]bool{"multiply": true, "replace": true, "sum": true, "avg": true, "max": true, "min": true}
+
+// parseFloatTag 解析数字类型的tag值
+func parseFloatTag(key, val string) (*float64, error) {
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return nil, errors.New(key + "值只能是数字")
+	}
+	return &f, nil
+}
+
+// checkScore 检查评分相关的tag
+func checkScore(tags *esTags) error {
+	if tags.Score == "" {
+		// 非评分字段的 scoreMode 由 checkNested 检查
+		if tags.BoostMode != "" {
+			return errors.New("boostMode 只能用于评分字段")
+		}
+		return nil
+	}
+	if !scoreTypes[tags.Score] {
+		return errors.New("score: " + tags.Score + " 不存在")
+	}
+	if tags.ScoreMode != "" && !scoreModes[tags.ScoreMode] {
+		return errors.New("scoreMode: " + tags.ScoreMode + " 不存在")
+	}
+	if tags.BoostMode != "" && !boostModes[tags.BoostMode] {
+		return errors.New("boostMode: " + tags.BoostMode + " 不存在")
+	}
+	if tags.Agg != "" || tags.Sort != "" {
+		return errors.New("评分字段不能使用agg或sort")
+	}
+	switch tags.Score {
+	case "gauss", "exp", "linear":
+		if tags.Scale == "" {
+			return errors.New(tags.Score + " 需要指定scale")
+		}
+	case "fieldValueFactor":
+		if tags.Modifier != "" && !scoreModifiers[tags.Modifier] {
+			return errors.New("modifier: " + tags.Modifier + " 不存在")
+		}
+	case "weight":
+		if tags.Weight == nil {
+			return errors.New("weight 需要指定weight")
+		}
+	case "negative":
+		if tags.NegativeBoost == nil || *tags.NegativeBoost < 0 || *tags.NegativeBoost > 1 {
+			return errors.New("negative 需要指定0到1之间的negativeBoost")
+		}
+	}
+	return nil
+}
+
+// setScore 评分字段不放入 query, 由 buildScore 统一处理
+func (t *StructToEsQuery) setScore(v reflect.Value) {
+	t.score = true
+	switch t.tags.Score {
+	case "gauss", "exp", "linear":
+		// 指针指向的(0,0)的点或0也是有效的原点, 非指针字段的零值视为未传入
+		t.val = t.getGeo(v)
+	case "fieldValueFactor", "random":
+		t.val = t.getScoreVal(v)
+	case "weight", "negative":
+		// bool 值为true时使用 字段=true 作为过滤条件
+		if vv := t.getBool(v); len(vv) > 0 {
+			t.val = nil
+			if vv[0] == true {
+				t.val = vv
+			}
+		}
+	}
+	root := t.getRoot()
+	root.scores = append(root.scores, t)
+}
+
+// getScoreVal 读取 fieldValueFactor/random 字段的值, nil指针和零值返回nil
+func (t *StructToEsQuery) getScoreVal(v reflect.Value) []interface{} {
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Invalid || v.IsZero() {
+		return nil
+	}
+	return []interface{}{v.Interface()}
+}
+
+// scoreFunction 评分字段对应的评分函数, 没有传入值时返回nil
+func (t *StructToEsQuery) scoreFunction() (filter elastic.Query, fn elastic.ScoreFunction) {
+	tags := t.tags
+	name := t.fields[0]
+	if t.parent != "" {
+		name = t.parent + "." + name
+	}
+	var val interface{}
+	if len(t.val) > 0 {
+		val = t.val[0]
+	}
+	switch tags.Score {
+	case "gauss", "exp", "linear":
+		origin, scale := val, interface{}(tags.Scale)
+		switch p := val.(type) {
+		case GeoPoint:
+			origin = elastic.GeoPointFromLatLon(p.Lat, p.Lon)
+		case GeoDistance:
+			origin = elastic.GeoPointFromLatLon(p.Lat, p.Lon)
+			if p.Distance != "" {
+				scale = p.Distance
+			}
+		case nil:
+			if tags.Origin == "" {
+				return
+			}
+			origin = tags.Origin
+		}
+		return nil, t.decayFunction(name, origin, scale)
+	case "fieldValueFactor":
+		if val == nil || val == false {
+			return
+		}
+		f := elastic.NewFieldValueFactorFunction().Field(name)
+		if tags.Factor != nil {
+			f.Factor(*tags.Factor)
+		}
+		if tags.Modifier != "" {
+			f.Modifier(tags.Modifier)
+		}
+		if tags.Missing != nil {
+			f.Missing(*tags.Missing)
+		}
+		return nil, f
+	case "random":
+		if val == nil {
+			return
+		}
+		return nil, elastic.NewRandomFunction().Seed(val).Field(name)
+	case "weight":
+		querys := t.valToQuery()
+		if len(querys) == 0 {
+			return
+		}
+		return t.wrapNested(elastic.NewBoolQuery().Filter(querys...)), elastic.NewWeightFactorFunction(*tags.Weight)
+	}
+	return
+}
+
+func (t *StructToEsQuery) decayFunction(name string, origin, scale interface{}) elastic.ScoreFunction {
+	tags := t.tags
+	switch tags.Score {
+	case "gauss":
+		f := elastic.NewGaussDecayFunction().FieldName(name).Origin(origin).Scale(scale)
+		if tags.Offset != "" {
+			f.Offset(tags.Offset)
+		}
+		if tags.Decay != nil {
+			f.Decay(*tags.Decay)
+		}
+		return f
+	case "exp":
+		f := elastic.NewExponentialDecayFunction().FieldName(name).Origin(origin).Scale(scale)
+		if tags.Offset != "" {
+			f.Offset(tags.Offset)
+		}
+		if tags.Decay != nil {
+			f.Decay(*tags.Decay)
+		}
+		return f
+	default:
+		f := elastic.NewLinearDecayFunction().FieldName(name).Origin(origin).Scale(scale)
+		if tags.Offset != "" {
+			f.Offset(tags.Offset)
+		}
+		if tags.Decay != nil {
+			f.Decay(*tags.Decay)
+		}
+		return f
+	}
+}
+
+// buildScore 使用评分字段包装查询, 有评分函数时使用 function_score, 有negative时使用 boosting
+// 包装后的查询放入新的bool查询的must中, 保持返回值为bool查询
+func (t *StructToEsQuery) buildScore(bq *elastic.BoolQuery) *elastic.BoolQuery {
+	root := t.getRoot()
+	if len(root.scores) == 0 {
+		return bq
+	}
+	var q elastic.Query = bq
+	fsq := elastic.NewFunctionScoreQuery().Query(bq)
+	functions := 0
+	for _, node := range root.scores {
+		if node.tags.ScoreMode != "" {
+			fsq.ScoreMode(node.tags.ScoreMode)
+		}
+		if node.tags.BoostMode != "" {
+			fsq.BoostMode(node.tags.BoostMode)
+		}
+		filter, fn := node.scoreFunction()
+		if fn == nil {
+			continue
+		}
+		fsq.Add(filter, fn)
+		functions++
+	}
+	if functions > 0 {
+		q = fsq
+	}
+	for _, node := range root.scores {
+		if node.tags.Score != "negative" {
+			continue
+		}
+		querys := node.valToQuery()
+		if len(querys) == 0 {
+			continue
+		}
+		q = elastic.NewBoostingQuery().
+			Positive(q).
+			Negative(node.wrapNested(elastic.NewBoolQuery().Must(querys...))).
+			NegativeBoost(*node.tags.NegativeBoost)
+	}
+	if q == elastic.Query(bq) {
+		return bq
+	}
+	return elastic.NewBoolQuery().Must(q)
+}
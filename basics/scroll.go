@@ -0,0 +1,155 @@
+package basics
+
+import (
+	"context"
+	"errors"
+	"github.com/olivere/elastic"
+	"io"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// ScrollOptions scroll 遍历的参数
+type ScrollOptions struct {
+	Size      int    // 每批返回的数量, 默认1000
+	KeepAlive string // scroll 上下文的保留时间, 默认1m
+	Slices    int    // 大于1时使用 sliced scroll, 每个分片一个goroutine并发查询
+	// Progress 每处理一条记录后调用, done 为已处理的数量, total 为匹配的总数
+	// 所有分片都返回第一批结果后才开始处理记录, 因此 total 不会变化
+	Progress func(done, total int64)
+}
+
+// Scroll 使用 scroll 遍历结构体查询匹配的所有文档, newScroll 每次调用需要返回新的 ScrollService, 如:
+//
+//	func() *elastic.ScrollService { return client.Scroll("index") }
+//
+// doc 为文档结构体指针, 只用于确定解码的类型, 如 (*Doc)(nil), 每条记录按 DecodeHit 的规则解码到新的对象后传给 fn
+// 查询结构体中 EsSelect 的 include/exclude 同样用于限制返回的 _source
+// fn 在调用 Scroll 的goroutine中串行调用, fn 未返回时不会继续拉取数据
+// fn 返回错误, 解码失败, 任意分片查询出错或 ctx 取消时停止遍历并返回对应的错误, 结束时清除所有 scroll 上下文
+func (t *StructToEsQuery) Scroll(ctx context.Context, newScroll func() *elastic.ScrollService, form interface{}, opt ScrollOptions, doc interface{}, fn func(doc interface{}, meta *HitMeta) error) error {
+	docType := reflect.TypeOf(doc)
+	if docType == nil || docType.Kind() != reflect.Ptr || docType.Elem().Kind() != reflect.Struct {
+		return errors.New("doc 必须为结构体指针")
+	}
+	query, err := t.ToQueryE(form)
+	if err != nil {
+		return err
+	}
+	var fsc *elastic.FetchSourceContext
+	if t.innerHits != nil && len(t.innerHits.GetInclude())+len(t.innerHits.GetExclude()) > 0 {
+		fsc = elastic.NewFetchSourceContext(true).Include(t.innerHits.GetInclude()...).Exclude(t.innerHits.GetExclude()...)
+	}
+	var q elastic.Query = query
+	if pf := t.GetPostFilter(); pf != nil {
+		q = elastic.NewBoolQuery().Must(query).Filter(pf)
+	}
+	sorters := t.GetSorters()
+	if opt.Size <= 0 {
+		opt.Size = 1000
+	}
+	if opt.KeepAlive == "" {
+		opt.KeepAlive = "1m"
+	}
+	if opt.Slices <= 0 {
+		opt.Slices = 1
+	}
+
+	scrollCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	hits := make(chan *elastic.SearchHit)
+	errs := make(chan error, opt.Slices)
+	var total int64
+	var wg sync.WaitGroup
+	// ready 等待所有分片的第一批结果, 之后 total 为所有分片的总数
+	var ready sync.WaitGroup
+	ready.Add(opt.Slices)
+	for i := 0; i < opt.Slices; i++ {
+		svc := newScroll().Query(q).Size(opt.Size).KeepAlive(opt.KeepAlive)
+		if len(sorters) > 0 {
+			svc.SortBy(sorters...)
+		} else {
+			svc.Sort("_doc", true)
+		}
+		if fsc != nil {
+			svc.FetchSourceContext(fsc)
+		}
+		if opt.Slices > 1 {
+			svc.Slice(elastic.NewSliceQuery().Id(i).Max(opt.Slices))
+		}
+		wg.Add(1)
+		go func(svc *elastic.ScrollService) {
+			defer wg.Done()
+			// ctx 可能已经取消, 使用新的 context 清除 scroll
+			defer func() { _ = svc.Clear(context.Background()) }()
+			first := true
+			defer func() {
+				if first {
+					ready.Done()
+				}
+			}()
+			for {
+				res, err := svc.Do(scrollCtx)
+				if err == io.EOF {
+					return
+				}
+				if err != nil {
+					errs <- err
+					// 停止其它分片
+					cancel()
+					return
+				}
+				if first {
+					atomic.AddInt64(&total, res.Hits.TotalHits)
+					first = false
+					ready.Done()
+				}
+				for _, hit := range res.Hits.Hits {
+					select {
+					case hits <- hit:
+					case <-scrollCtx.Done():
+						return
+					}
+				}
+			}
+		}(svc)
+	}
+	go func() {
+		wg.Wait()
+		close(hits)
+	}()
+
+	ready.Wait()
+	var fnErr error
+	var done int64
+	for hit := range hits {
+		if fnErr != nil {
+			continue
+		}
+		v := reflect.New(docType.Elem())
+		if fnErr = decodeHit(hit, v.Elem(), t.innerPaths); fnErr == nil {
+			fnErr = fn(v.Interface(), newHitMeta(hit))
+		}
+		if fnErr != nil {
+			cancel()
+			continue
+		}
+		done++
+		if opt.Progress != nil {
+			opt.Progress(done, atomic.LoadInt64(&total))
+		}
+	}
+	if fnErr != nil {
+		return fnErr
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case err = <-errs:
+		return err
+	default:
+		return nil
+	}
+}
@@ -2,49 +2,87 @@ package basics
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/olivere/elastic"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 )
 
-var customSearchGlobal func(string) []elastic.Query
-var customSorterGlobal func(string) []elastic.Sorter
-
 type StructToEsQuery struct {
+	root   *StructToEsQuery // 根节点, 子节点共享根节点上的自定义回调
+	custom *esCustom
+	plan   *Plan // 根节点使用的解析计划, 为空时按传入结构体的类型从缓存中获取
+	aggs   map[string]elastic.Aggregation
+	// facet 模式下的聚合字段及 post_filter, 只在根节点上使用
+	facetMode  bool
+	facets     []*esFacet
+	postFilter *elastic.BoolQuery
+	err        error              // 解析结构体的值时的第一个错误, 只在根节点上使用
+	scores     []*StructToEsQuery // 评分字段, 只在根节点上使用
+	// highlightKeys 高亮字段的es字段名与json名称的对应关系, 只在根节点上使用
+	highlightKeys map[string]string
+	// innerPaths 指定了名称的 inner_hits 与nested路径(join类型)的对应关系, 用于解码结果, 只在根节点上使用
+	innerPaths map[string]string
+
 	// {must/not/should/filter/group: map[string]*StructToEsQuery}
 	logical map[string]map[string]*StructToEsQuery
 	sorters map[int][]elastic.Sorter
 	levels  []int
 
-	type_      string // nested, obj, field, logical, group
+	type_      string // nested, obj, hasChild, hasParent, field, logical, group
 	innerHits  EsInnerHits
+	innerName  string             // inner_hits 的名称, 为空时使用es默认的名称
+	highlight  *elastic.Highlight // 根节点为查询的高亮, nested/hasChild/hasParent 节点为 inner_hits 的高亮
 	relational string
-	parent     string
-	fields     []string
-	val        []interface{}
-	querys     []elastic.Query
+	tags       *esTags
+	facet      bool // facet 模式下聚合字段的查询条件不放入 query
+	// outerGroup 字段的logical中最外层的分组节点及该分组使用的逻辑运算, facet 模式下整个分组作为聚合字段的条件
+	outerGroup   *StructToEsQuery
+	outerLogical string
+	score        bool // 评分字段的查询条件不放入 query
+	parent       string
+	nestedPath   []string         // 当前节点所在的nested路径, 由外到内
+	scope        *StructToEsQuery // 当前节点所在的 nested/hasChild/hasParent 节点, 用于设置 innerHits
+	fields       []string
+	val          []interface{}
+	querys       []elastic.Query
+	group        *esGroup // 分组节点的参数
+	splitVals    bool     // 多个值时每个值生成一个should子句, 用于 minimum_should_match 按值计数
 }
 
 func NewStructToEsQuery() *StructToEsQuery {
-	return &StructToEsQuery{}
+	res := &StructToEsQuery{custom: newEsCustom()}
+	res.root = res
+	return res
 }
 
 func NewStructToEsQueryAndCustomSearch(customSearch func(string) []elastic.Query) *StructToEsQuery {
-	customSearchGlobal = customSearch
-	return NewStructToEsQuery()
+	return NewStructToEsQuery().SetCustomSearch(customSearch)
 }
 
 func NewStructToEsQueryAndCustomSorter(customSorter func(string) []elastic.Sorter) *StructToEsQuery {
-	customSorterGlobal = customSorter
-	return NewStructToEsQuery()
+	return NewStructToEsQuery().SetCustomSorter(customSorter)
 }
 
 func NewStructToEsQueryAndCustom(customSearch func(string) []elastic.Query, customSorter func(string) []elastic.Sorter) *StructToEsQuery {
-	customSearchGlobal = customSearch
-	return NewStructToEsQueryAndCustomSorter(customSorter)
+	return NewStructToEsQuery().SetCustomSearch(customSearch).SetCustomSorter(customSorter)
+}
+
+// newChild 创建子节点, 子节点与当前节点共享根节点
+func (t *StructToEsQuery) newChild() *StructToEsQuery {
+	return &StructToEsQuery{root: t.getRoot()}
+}
+
+func (t *StructToEsQuery) getRoot() *StructToEsQuery {
+	if t.root == nil {
+		// 兼容直接使用 new(StructToEsQuery) 创建的对象
+		t.root = t
+	}
+	return t.root
 }
 
 func (t *StructToEsQuery) setLogical(logical, key string, val *StructToEsQuery) *StructToEsQuery {
@@ -83,6 +121,13 @@ func (t *StructToEsQuery) setParent(parent, val string) {
 	t.parent = parent + "." + val
 }
 
+// addNestedPath 将当前节点的parent作为nested路径
+func (t *StructToEsQuery) addNestedPath() {
+	paths := make([]string, len(t.nestedPath), len(t.nestedPath)+1)
+	copy(paths, t.nestedPath)
+	t.nestedPath = append(paths, t.parent)
+}
+
 func (t *StructToEsQuery) getNames(fieldName string, tag reflect.StructTag) []string {
 	// name 优先级 esFields > esField > json > fieldName
 	esFields := tag.Get("fields")
@@ -127,9 +172,23 @@ func (t *StructToEsQuery) getVal(v reflect.Value) []interface{} {
 	}
 }
 
+// getBool 读取bool值, 用于exists, nil指针返回nil
+func (t *StructToEsQuery) getBool(v reflect.Value) []interface{} {
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Bool {
+		return nil
+	}
+	return []interface{}{v.Bool()}
+}
+
 type esTags struct {
 	Nesting    string
 	Logical    []string
+	Groups     []*esGroup // 与Logical一一对应的分组参数, 没有参数时为nil
+	Msm        string     // 直接包含该字段的bool查询的 minimum_should_match
+	GroupBoost *float64   // 最后一个分组的boost, 同分组参数 {boost:..}
 	Relational string
 
 	Sort  string
@@ -139,28 +198,97 @@ type esTags struct {
 
 	Custom bool
 	Block  bool
+
+	Agg         string // terms/cardinality/min/max/avg/sum/stats/date_histogram/histogram/range
+	Size        int
+	Interval    string
+	Format      string
+	Ranges      []aggRange
+	MinDocCount *int64
+
+	Rewrite         string // prefix/wildcard/regexp
+	Pattern         string
+	Flags           string
+	Raw             bool
+	CaseInsensitive bool
+
+	MultiType string // multiMatch 的类型
+
+	// match 系列查询的参数
+	Fuzziness          string
+	PrefixLength       *int
+	Analyzer           string
+	MinimumShouldMatch string
+	Boost              *float64
+	ZeroTermsQuery     string
+	Lenient            bool
+
+	Allow    []string // queryString 允许的语法
+	Sanitize bool     // simpleQueryString/queryString 转义所有保留字符
+
+	Distance      string // geoDistance 未传入距离时使用的距离
+	DistanceType  string // arc/plane
+	Unit          string // sort:geo 距离的单位
+	DistanceField string // sort:geo 解码结果时写入距离的字段(json名称)
+
+	Relation string // range 系列查询字段为范围类型时的关系: INTERSECTS/WITHIN/CONTAINS
+
+	TimeZone string // dateRange/date_histogram 的时区
+	Round    string // dateRange 的取整方式, 如 /d
+	DateMath string // dateRange 日期计算允许的单位, 如 yMwd
+
+	// 评分字段的参数
+	Score         string // gauss/exp/linear/fieldValueFactor/weight/random/negative
+	Origin        string
+	Scale         string
+	Offset        string
+	Decay         *float64
+	Factor        *float64
+	Modifier      string
+	Missing       *float64
+	Weight        *float64
+	NegativeBoost *float64
+	ScoreMode     string // 评分字段为 function_score 的 score_mode, nested/hasChild/hasParent 为对应查询的 score_mode
+	BoostMode     string
+
+	// 父子文档查询的参数
+	Join        string // hasChild/hasParent/parentId 的join类型
+	MinChildren *int
+	MaxChildren *int
+
+	IgnoreUnmapped bool   // nested/hasParent/parentId 忽略未映射的字段
+	Name           string // innerHits 的名称
+
+	// match 系列查询的高亮参数
+	Highlight    string // default/unified/plain/fvh, default 使用es默认的高亮类型
+	FragmentSize *int
+	Fragments    *int
+	PreTags      []string
+	PostTags     []string
 }
 
-func (t *StructToEsQuery) getTags(tag string) (res *esTags) {
+func (t *StructToEsQuery) getTags(tag string) (res *esTags, err error) {
 	if tag == "-" {
-		return nil
+		return nil, nil
 	}
 	res = new(esTags)
 	res.Logical = []string{"must"}
 	res.Type = "string"
 	for _, v := range strings.Split(tag, ";") {
-		kv := strings.Split(v, ":")
+		kv := strings.SplitN(v, ":", 2)
 		length := len(kv)
 		if length == 0 {
 			continue
 		} else if length >= 2 {
 			switch kv[0] {
 			case "nesting":
-				res.Nesting = kv[1]
+				res.Nesting, res.Join = parseJoin(kv[1])
 			case "logical":
-				res.Logical = strings.Split(kv[1], ",")
+				if res.Logical, res.Groups, err = parseLogical(kv[1]); err != nil {
+					return nil, err
+				}
 			case "relational":
-				res.Relational = kv[1]
+				res.Relational, res.Join = parseJoin(kv[1])
 			case "sort":
 				res.Sort = kv[1]
 			case "mode":
@@ -168,19 +296,158 @@ func (t *StructToEsQuery) getTags(tag string) (res *esTags) {
 			case "level":
 				res.Level = jsoniter.WrapString(kv[1]).ToInt()
 				if res.Level == 0 && kv[1] != "0" {
-					panic("level值只能是整数")
+					return nil, errors.New("level值只能是整数")
 				}
 			case "type":
 				res.Type = kv[1]
+			case "agg":
+				res.Agg = kv[1]
+			case "size":
+				if res.Size, err = strconv.Atoi(kv[1]); err != nil {
+					return nil, errors.New("size值只能是整数")
+				}
+			case "interval":
+				res.Interval = kv[1]
+			case "format":
+				res.Format = kv[1]
+			case "ranges":
+				if res.Ranges, err = parseAggRanges(kv[1]); err != nil {
+					return nil, err
+				}
+			case "rewrite":
+				res.Rewrite = kv[1]
+			case "pattern":
+				res.Pattern = kv[1]
+			case "flags":
+				res.Flags = kv[1]
+			case "multiType":
+				res.MultiType = kv[1]
+			case "fuzziness":
+				res.Fuzziness = kv[1]
+			case "prefixLength":
+				n, e := strconv.Atoi(kv[1])
+				if e != nil {
+					return nil, errors.New("prefixLength值只能是整数")
+				}
+				res.PrefixLength = &n
+			case "analyzer":
+				res.Analyzer = kv[1]
+			case "minimumShouldMatch":
+				res.MinimumShouldMatch = kv[1]
+			case "boost":
+				f, e := strconv.ParseFloat(kv[1], 64)
+				if e != nil {
+					return nil, errors.New("boost值只能是数字")
+				}
+				res.Boost = &f
+			case "zeroTermsQuery":
+				res.ZeroTermsQuery = kv[1]
+			case "allow":
+				res.Allow = strings.Split(kv[1], ",")
+			case "distance":
+				res.Distance = kv[1]
+			case "distanceType":
+				res.DistanceType = kv[1]
+			case "unit":
+				res.Unit = kv[1]
+			case "distanceField":
+				res.DistanceField = kv[1]
+			case "relation":
+				res.Relation = strings.ToUpper(kv[1])
+			case "timeZone":
+				res.TimeZone = kv[1]
+			case "round":
+				res.Round = kv[1]
+			case "dateMath":
+				res.DateMath = kv[1]
+			case "msm":
+				res.Msm = kv[1]
+			case "groupBoost":
+				res.GroupBoost, err = parseFloatTag(kv[0], kv[1])
+			case "score":
+				res.Score = kv[1]
+			case "origin":
+				res.Origin = kv[1]
+			case "scale":
+				res.Scale = kv[1]
+			case "offset":
+				res.Offset = kv[1]
+			case "modifier":
+				res.Modifier = kv[1]
+			case "scoreMode":
+				res.ScoreMode = kv[1]
+			case "boostMode":
+				res.BoostMode = kv[1]
+			case "decay":
+				res.Decay, err = parseFloatTag(kv[0], kv[1])
+			case "factor":
+				res.Factor, err = parseFloatTag(kv[0], kv[1])
+			case "missing":
+				res.Missing, err = parseFloatTag(kv[0], kv[1])
+			case "weight":
+				res.Weight, err = parseFloatTag(kv[0], kv[1])
+			case "negativeBoost":
+				res.NegativeBoost, err = parseFloatTag(kv[0], kv[1])
+			case "minDocCount":
+				n, e := strconv.ParseInt(kv[1], 10, 64)
+				if e != nil {
+					return nil, errors.New("minDocCount值只能是整数")
+				}
+				res.MinDocCount = &n
+			case "name":
+				res.Name = kv[1]
+			case "highlight":
+				res.Highlight = kv[1]
+			case "fragmentSize", "fragments":
+				n, e := strconv.Atoi(kv[1])
+				if e != nil {
+					return nil, errors.New(kv[0] + "值只能是整数")
+				}
+				if kv[0] == "fragmentSize" {
+					res.FragmentSize = &n
+				} else {
+					res.Fragments = &n
+				}
+			case "preTags":
+				res.PreTags = strings.Split(kv[1], ",")
+			case "postTags":
+				res.PostTags = strings.Split(kv[1], ",")
+			case "minChildren", "maxChildren":
+				n, e := strconv.Atoi(kv[1])
+				if e != nil {
+					return nil, errors.New(kv[0] + "值只能是整数")
+				}
+				if kv[0] == "minChildren" {
+					res.MinChildren = &n
+				} else {
+					res.MaxChildren = &n
+				}
+			default:
+				return nil, errors.New(kv[0] + " 不存在")
+			}
+			if err != nil {
+				return nil, err
 			}
 			continue
 		}
+		if name, join := parseJoin(v); join != "" {
+			// hasChild@type/hasParent@type/parentId@type
+			if name == "parentId" {
+				res.Relational = name
+			} else {
+				res.Nesting = name
+			}
+			res.Join = join
+			continue
+		}
 		switch v {
-		case "nested", "obj", "innerHits":
+		case "nested", "obj", "innerHits", "hasChild", "hasParent":
 			res.Nesting = v
-		case "must", "not", "filter", "should":
+		case "must", "not", "filter", "should", "postFilter":
 			res.Logical = []string{v}
-		case "match", "matchAnd", "range", "lt", "lte", "gt", "gte":
+		case "match", "matchAnd", "range", "lt", "lte", "gt", "gte", "prefix", "wildcard", "regexp", "exists",
+			"matchPhrase", "matchPhrasePrefix", "multiMatch", "simpleQueryString", "queryString",
+			"geoDistance", "geoBoundingBox", "geoPolygon", "dateRange", "overlap", "parentId":
 			res.Relational = v
 		case "sort":
 			res.Sort = "default"
@@ -188,26 +455,45 @@ func (t *StructToEsQuery) getTags(tag string) (res *esTags) {
 			res.Custom = true
 		case "block":
 			res.Block = true
+		case "raw":
+			res.Raw = true
+		case "caseInsensitive":
+			res.CaseInsensitive = true
+		case "lenient":
+			res.Lenient = true
+		case "sanitize":
+			res.Sanitize = true
+		case "ignoreUnmapped":
+			res.IgnoreUnmapped = true
+		case "highlight":
+			res.Highlight = "default"
+		case "":
+			// 空tag或多余的分号
+		default:
+			return nil, errors.New(v + " 不存在")
 		}
 	}
+	res.moveGroupOptions()
 	return
 }
 
 func (t *StructToEsQuery) analysisLogical(name string, tags *esTags) *StructToEsQuery {
 	this := t
+	container := t // 直接包含该字段的bool查询对应的节点
+	var outerGroup *StructToEsQuery
+	outerLogical := ""
 	length := len(tags.Logical)
 	for j, logical := range tags.Logical {
 		ss := strings.Split(logical, "@")
 		group := ""
 		if len(ss) > 1 {
-			if j == length-1 && tags.Nesting != "innerHits" {
-				panic("分组不能作为最后一个逻辑运算")
-			}
 			logical = ss[0]
 			group = ss[1]
 		}
-		next := new(StructToEsQuery)
+		next := t.newChild()
 		next.parent = this.parent
+		next.nestedPath = this.nestedPath
+		next.scope = this.scope
 		next.type_ = "logical"
 		key := ""
 		if j == length-1 {
@@ -218,37 +504,52 @@ func (t *StructToEsQuery) analysisLogical(name string, tags *esTags) *StructToEs
 				key = nextLogical[1]
 				next.type_ = "nested"
 				next.setParent(this.parent, key)
+				next.addNestedPath()
+				next.scope = next
 			}
 		}
 		switch logical {
-		case "must", "not", "should", "filter":
+		case "must", "not", "should", "filter", "postFilter":
+			container = this
 			this = this.setLogical(logical, key, next)
 		case "nested":
 			// nested 作为path解析不分组解析
 			group = ""
-		default:
-			panic(logical + ": 仅支持传入 must, not, should, filter")
 		}
 		if group == "" {
 			continue
 		}
 		if this.getLogicalStruct("group", group) != nil {
 			this = this.getLogicalStruct("group", group)
-			continue
+		} else {
+			next = t.newChild()
+			next.nestedPath = this.nestedPath
+			next.scope = this.scope
+			next.type_ = "group"
+			this = this.setLogical("group", group, next)
 		}
-		next = new(StructToEsQuery)
-		next.type_ = "group"
-		this = this.setLogical("group", group, next)
+		if outerGroup == nil {
+			outerGroup, outerLogical = this, logical
+		}
+		if this.group == nil && tags.Groups != nil {
+			// 分组参数只需要在组内的一个字段上设置
+			this.group = tags.Groups[j]
+		}
+	}
+	if tags.Msm != "" && container.group == nil {
+		container.group = &esGroup{Msm: tags.Msm}
 	}
+	this.outerGroup, this.outerLogical = outerGroup, outerLogical
 	return this
 }
 
-func (t *StructToEsQuery) setSorter(fields []string, tags *esTags, val reflect.Value) {
+func (t *StructToEsQuery) setSorter(f *planField, val reflect.Value) {
+	fields, tags := f.names, f.tags
 	if tags.Sort == "nested" {
-		this := new(StructToEsQuery)
+		this := t.newChild()
 		this.type_ = "nestedSort"
 		this.setParent(t.parent, fields[0])
-		this.analysis(val)
+		this.analysisPlan(f.child, val)
 		querys := this.toQuery()
 		if len(querys) == 0 {
 			return
@@ -263,9 +564,13 @@ func (t *StructToEsQuery) setSorter(fields []string, tags *esTags, val reflect.V
 					t.levels = append(t.levels, level)
 					t.sorters[tags.Level] = make([]elastic.Sorter, 0)
 				}
-				sorter.(*elastic.FieldSort).Nested(
-					elastic.NewNestedSort(this.parent).Filter(querys[0]),
-				)
+				nested := elastic.NewNestedSort(this.parent).Filter(querys[0])
+				switch s := sorter.(type) {
+				case *elastic.FieldSort:
+					s.Nested(nested)
+				case *geoDistanceSort:
+					s.NestedSort(nested)
+				}
 				t.sorters[level] = append(t.sorters[level], sorter)
 			}
 		}
@@ -280,10 +585,11 @@ func (t *StructToEsQuery) setSorter(fields []string, tags *esTags, val reflect.V
 		t.sorters[tags.Level] = make([]elastic.Sorter, 0)
 	}
 	if tags.Custom {
-		if customSorterGlobal == nil {
-			return
-		}
-		t.sorters[tags.Level] = append(t.sorters[tags.Level], customSorterGlobal(fields[0])...)
+		t.sorters[tags.Level] = append(t.sorters[tags.Level], t.getRoot().getCustom().sorter(fields[0])...)
+		return
+	}
+	if tags.Sort == "geo" {
+		t.setGeoSorter(f, val)
 		return
 	}
 	vv := t.getVal(val)
@@ -291,13 +597,14 @@ func (t *StructToEsQuery) setSorter(fields []string, tags *esTags, val reflect.V
 		return
 	}
 	for _, field := range fields {
+		if t.parent != "" {
+			// nested 结构体中的排序字段用于 inner_hits 的排序, 需要完整路径
+			field = t.parent + "." + field
+		}
 		if t.type_ == "nestedSort" {
 			if tags.Sort != "default" {
 				continue
 			}
-			if t.parent != "" {
-				field = t.parent + "." + field
-			}
 			sorter := elastic.NewFieldSort(field).Order(vv[0] == 2).SortMode(tags.Mode)
 			t.sorters[tags.Level] = append(t.sorters[tags.Level], sorter)
 			continue
@@ -322,23 +629,49 @@ func (t *StructToEsQuery) setSorter(fields []string, tags *esTags, val reflect.V
 	}
 }
 
-func (t *StructToEsQuery) analysis(value reflect.Value) {
-	if value.Kind() == reflect.Ptr {
-		t.analysis(value.Elem())
-		return
+func (t *StructToEsQuery) analysis(value reflect.Value) (err error) {
+	for value.Kind() == reflect.Ptr {
+		value = value.Elem()
 	}
 	if value.Kind() == reflect.Invalid {
 		return
 	}
-	typ := value.Type()
-	for i := 0; i < value.NumField(); i++ {
-		v := value.Field(i)
-		tt := typ.Field(i)
-		tags := t.getTags(tt.Tag.Get("es"))
-		if tags == nil {
-			continue
+	plan := t.plan
+	if plan == nil || plan.typ != value.Type() {
+		if plan, err = Compile(value.Type()); err != nil {
+			return
 		}
-		if tt.Anonymous || tags.Block { // 匿名字段或分块结构(单纯为了结构分块而添加的嵌套结构)
+	}
+	t.buildAggs(plan, "", nil, make(map[*Plan]bool))
+	t.analysisPlan(plan, value)
+	return
+}
+
+// setErr 记录解析结构体的值时的第一个错误
+func (t *StructToEsQuery) setErr(err error) {
+	if root := t.getRoot(); root.err == nil {
+		root.err = err
+	}
+}
+
+// Err 返回解析传入的值时的第一个错误(*InputError), 没有错误时返回nil
+// ToQuery/ToSearchBody 遇到该错误时不会panic, 生成不匹配任何文档的查询, 需要时在调用后通过 Err 检查
+func (t *StructToEsQuery) Err() error {
+	return t.getRoot().err
+}
+
+// analysisPlan 按解析计划读取结构体的值, 不再解析tag
+func (t *StructToEsQuery) analysisPlan(plan *Plan, value reflect.Value) {
+	for value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+	if value.Kind() == reflect.Invalid {
+		return
+	}
+	for _, f := range plan.fields {
+		v := value.Field(f.index)
+		tags := f.tags
+		if f.anonymous || tags.Block { // 匿名字段或分块结构(单纯为了结构分块而添加的嵌套结构)
 			if tags.Nesting == "innerHits" {
 				if v.Kind() != reflect.Ptr {
 					v = v.Addr()
@@ -348,46 +681,77 @@ func (t *StructToEsQuery) analysis(value reflect.Value) {
 				}
 				continue
 			}
-			t.analysis(v)
+			t.analysisPlan(f.child, v)
 			continue
 		}
-		fields := t.getNames(tt.Name, tt.Tag)
+		fields := f.names
 		if tags.Sort != "" {
-			t.setSorter(fields, tags, v)
+			t.setSorter(f, v)
 			continue
 		}
-		this := t.analysisLogical(tt.Name, tags)
+		this := t.analysisLogical(f.name, tags)
 		switch tags.Nesting {
 		case "nested", "obj":
 			this.type_ = tags.Nesting
 			this.fields = fields
 			this.setParent(t.parent, fields[0])
-			this.analysis(v)
+			if tags.Nesting == "nested" {
+				// 每一层nested使用自己的 scoreMode/ignoreUnmapped/innerHits
+				this.tags = tags
+				this.scope = this
+				this.addNestedPath()
+			}
+			this.analysisPlan(f.child, v)
+		case "hasChild", "hasParent":
+			// 子/父文档是独立的文档, 字段不需要添加前缀, 也不在当前的nested路径中
+			this.type_ = tags.Nesting
+			this.tags = tags
+			this.fields = fields
+			this.parent = ""
+			this.nestedPath = nil
+			this.scope = this
+			this.analysisPlan(f.child, v)
 		case "innerHits":
 			if v.IsNil() {
 				continue
 			}
-			if this.type_ == "nested" {
-				this.innerHits = v.Interface().(EsInnerHits)
-			} else if t.type_ == "nested" {
-				t.innerHits = v.Interface().(EsInnerHits)
-			}
+			this.setInnerHits(tags, v.Interface().(EsInnerHits))
 		default:
 			if tags.Custom {
-				if customSearchGlobal == nil {
-					continue
-				}
 				this.type_ = "custom"
-				this.querys = append(this.querys, customSearchGlobal(fields[0])...)
+				this.querys = append(this.querys, t.getRoot().getCustom().search(fields[0])...)
 				continue
 			}
 			this.type_ = "val"
 			this.relational = tags.Relational
+			this.tags = tags
 			this.fields = fields
-			this.val = this.getVal(v)
+			switch {
+			case f.isRange:
+				this.val = this.getRange(v)
+			case tags.Relational == "exists":
+				this.val = this.getBool(v)
+			case tags.Relational == "geoDistance", tags.Relational == "geoBoundingBox", tags.Relational == "geoPolygon":
+				this.val = this.getGeo(v)
+			case tags.Relational == "dateRange":
+				var err error
+				if this.val, err = this.getDateVal(v); err != nil {
+					t.setErr(&InputError{Field: f.key, Reason: err.Error()})
+				}
+			default:
+				this.val = this.getVal(v)
+			}
+			if tags.Highlight != "" && len(this.val) > 0 {
+				this.setHighlight(f)
+			}
+			if tags.Agg != "" && t.getRoot().facetMode {
+				this.setFacet(f)
+			}
+			if tags.Score != "" {
+				this.setScore(v)
+			}
 		}
 	}
-	return
 }
 
 func (t *StructToEsQuery) valToQuery() (query []elastic.Query) {
@@ -395,55 +759,88 @@ func (t *StructToEsQuery) valToQuery() (query []elastic.Query) {
 	if length == 0 {
 		return
 	}
+	if t.relational == "multiMatch" {
+		return t.multiMatchToQuery()
+	}
+	if t.relational == "simpleQueryString" || t.relational == "queryString" {
+		return t.queryStringToQuery()
+	}
+	if t.relational == "overlap" {
+		return t.overlapToQuery()
+	}
+	if t.relational == "parentId" {
+		return t.parentIdToQuery()
+	}
 	for _, name := range t.fields {
 		if t.parent != "" {
 			name = t.parent + "." + name
 		}
+		if r, ok := t.val[0].(esRange); ok {
+			query = append(query, t.rangeQuery(name, r))
+			continue
+		}
 		switch t.relational {
 		case "":
 			if length == 1 {
 				query = append(query, elastic.NewTermQuery(name, t.val[0]))
+			} else if t.splitVals {
+				for _, v := range t.val {
+					query = append(query, elastic.NewTermQuery(name, v))
+				}
 			} else {
 				query = append(query, elastic.NewTermsQuery(name, t.val...))
 			}
-		case "match":
+		case "match", "matchAnd", "matchPhrase", "matchPhrasePrefix":
 			if length == 1 {
-				query = append(query, elastic.NewMatchQuery(name, t.val[0]))
+				query = append(query, t.newMatchQuery(name, t.val[0]))
 				continue
 			}
-			q := elastic.NewBoolQuery()
-			for _, v := range t.val {
-				q.Should(elastic.NewMatchQuery(name, v))
+			qs := make([]elastic.Query, length)
+			for i, v := range t.val {
+				qs[i] = t.newMatchQuery(name, v)
 			}
-			query = append(query, q)
-		case "matchAnd":
+			query = append(query, t.shouldQuery(qs)...)
+		case "prefix", "wildcard", "regexp":
 			if length == 1 {
-				query = append(query, elastic.NewMatchQuery(name, t.val[0]).Operator("and"))
+				query = append(query, t.termLevelQuery(name, t.val[0]))
 				continue
 			}
-			q := elastic.NewBoolQuery()
-			for _, v := range t.val {
-				q.Should(elastic.NewMatchQuery(name, v).Operator("and"))
+			qs := make([]elastic.Query, length)
+			for i, v := range t.val {
+				qs[i] = t.termLevelQuery(name, v)
+			}
+			query = append(query, t.shouldQuery(qs)...)
+		case "dateRange":
+			query = append(query, t.dateRangeQuery(name))
+		case "geoDistance", "geoBoundingBox", "geoPolygon":
+			if q := t.geoQuery(name, t.val[0]); q != nil {
+				query = append(query, q)
+			}
+		case "exists":
+			// true 存在该字段, false 不存在该字段
+			if t.val[0] == true {
+				query = append(query, elastic.NewExistsQuery(name))
+			} else {
+				query = append(query, elastic.NewBoolQuery().MustNot(elastic.NewExistsQuery(name)))
 			}
-			query = append(query, q)
 		case "range":
 			if length == 1 {
-				query = append(query, elastic.NewRangeQuery(name).Gte(t.val[0]))
+				query = append(query, t.newRangeQuery(name).Gte(t.val[0]))
 			} else if t.val[0] == "" {
-				query = append(query, elastic.NewRangeQuery(name).Lte(t.val[1]))
+				query = append(query, t.newRangeQuery(name).Lte(t.val[1]))
 			} else {
-				query = append(query, elastic.NewRangeQuery(name).Gte(t.val[0]).Lte(t.val[1]))
+				query = append(query, t.newRangeQuery(name).Gte(t.val[0]).Lte(t.val[1]))
 			}
 		case "rangeLte":
 			if length == 1 {
-				query = append(query, elastic.NewRangeQuery(name).Lte(t.val[0]))
+				query = append(query, t.newRangeQuery(name).Lte(t.val[0]))
 			} else if t.val[0] == "" {
-				query = append(query, elastic.NewRangeQuery(name).Lte(t.val[1]))
+				query = append(query, t.newRangeQuery(name).Lte(t.val[1]))
 			} else {
-				query = append(query, elastic.NewRangeQuery(name).Gte(t.val[0]).Lte(t.val[1]))
+				query = append(query, t.newRangeQuery(name).Gte(t.val[0]).Lte(t.val[1]))
 			}
 		case "rangeIgnore0":
-			rangeQuery := elastic.NewRangeQuery(name)
+			rangeQuery := t.newRangeQuery(name)
 			if !reflect.ValueOf(t.val[0]).IsZero() {
 				rangeQuery.Gte(t.val[0])
 			}
@@ -456,10 +853,10 @@ func (t *StructToEsQuery) valToQuery() (query []elastic.Query) {
 				if reflect.ValueOf(t.val[0]).IsZero() {
 					continue
 				}
-				query = append(query, elastic.NewRangeQuery(name).Lte(t.val[0]))
+				query = append(query, t.newRangeQuery(name).Lte(t.val[0]))
 				continue
 			}
-			rangeQuery := elastic.NewRangeQuery(name)
+			rangeQuery := t.newRangeQuery(name)
 			if !reflect.ValueOf(t.val[0]).IsZero() {
 				rangeQuery.Gte(t.val[0])
 			}
@@ -468,22 +865,31 @@ func (t *StructToEsQuery) valToQuery() (query []elastic.Query) {
 			}
 			query = append(query, rangeQuery)
 		case "lt":
-			query = append(query, elastic.NewRangeQuery(name).Lt(t.val[0]))
+			query = append(query, t.newRangeQuery(name).Lt(t.val[0]))
 		case "lte":
-			query = append(query, elastic.NewRangeQuery(name).Lte(t.val[0]))
+			query = append(query, t.newRangeQuery(name).Lte(t.val[0]))
 		case "gt":
-			query = append(query, elastic.NewRangeQuery(name).Gt(t.val[0]))
+			query = append(query, t.newRangeQuery(name).Gt(t.val[0]))
 		case "gte":
-			query = append(query, elastic.NewRangeQuery(name).Gte(t.val[0]))
-		default:
-			panic("relational: " + t.relational + " 不存在")
+			query = append(query, t.newRangeQuery(name).Gte(t.val[0]))
 		}
 	}
 	return
 }
 
+// shouldQuery 多个值生成的查询使用should连接, splitVals 时直接作为所在bool查询的子句
+func (t *StructToEsQuery) shouldQuery(qs []elastic.Query) []elastic.Query {
+	if t.splitVals {
+		return qs
+	}
+	return []elastic.Query{elastic.NewBoolQuery().Should(qs...)}
+}
+
 func (t *StructToEsQuery) mapToQuery(val map[string]*StructToEsQuery) (query []elastic.Query) {
 	for _, v := range val {
+		if v.facet || v.score {
+			continue
+		}
 		if v.type_ == "obj" || v.type_ == "nested" {
 			query = append(query, v.toQuery()...)
 			continue
@@ -509,48 +915,114 @@ func (t *StructToEsQuery) toQuery() (query []elastic.Query) {
 	}
 	use := false
 	bq := elastic.NewBoolQuery()
+	var all []elastic.Query // must/should 的查询, 用于 dis_max 分组
+	var not, filter []elastic.Query
 	for logical, info := range t.logical {
 		if info == nil || logical == "group" {
 			continue
 		}
+		if logical == "postFilter" && t == t.getRoot() {
+			// 根节点的 postFilter 由 GetPostFilter 生成
+			continue
+		}
+		if logical == "should" && t.group != nil && t.group.Msm != "" {
+			// minimum_should_match 按should子句计数, 多个值的字段每个值生成一个子句
+			for _, v := range info {
+				v.splitVals = true
+			}
+		}
 		qs := t.mapToQuery(info)
 		use = use || len(qs) > 0
 		switch logical {
 		case "must":
 			bq.Must(qs...)
+			all = append(all, qs...)
 		case "not":
 			bq.MustNot(qs...)
+			not = append(not, qs...)
 		case "should":
 			bq.Should(qs...)
-		case "filter":
+			all = append(all, qs...)
+		case "filter", "postFilter":
 			bq.Filter(qs...)
+			filter = append(filter, qs...)
 		}
 	}
 	if !use {
 		return
 	}
-	if t.type_ != "nested" {
-		query = append(query, bq)
+	var q elastic.Query = bq
+	if t.group != nil {
+		q = t.groupQuery(bq, all, not, filter)
+	}
+	if isJoin(t.type_) {
+		query = append(query, t.joinQuery(q))
 		return
 	}
-	nq := elastic.NewNestedQuery(t.parent, bq)
-	if t.innerHits != nil {
-		nq.InnerHit(t.innerHits.InitInnerHits())
+	if t.type_ != "nested" {
+		query = append(query, q)
+		return
 	}
-	query = append(query, nq)
+	query = append(query, t.nestedQuery(q))
 	return
 }
 
+// ToQuery 解析结构体生成查询, tag错误时panic *TagError, 不希望panic时使用 ToQueryE
+// 传入的值不合法时返回不匹配任何文档的查询, 错误通过 Err 获取
 func (t *StructToEsQuery) ToQuery(form interface{}) *elastic.BoolQuery {
-	t.analysis(reflect.ValueOf(form))
+	res, err := t.buildQuery(form)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// ToQueryE 同 ToQuery, tag错误时返回 *TagError, 传入的值不合法时返回 *InputError
+func (t *StructToEsQuery) ToQueryE(form interface{}) (*elastic.BoolQuery, error) {
+	res, err := t.buildQuery(form)
+	if err == nil {
+		err = t.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// buildQuery 生成查询, 只返回 *TagError, 传入的值不合法时返回不匹配任何文档的查询
+func (t *StructToEsQuery) buildQuery(form interface{}) (*elastic.BoolQuery, error) {
+	t.reset()
+	if err := t.analysis(reflect.ValueOf(form)); err != nil {
+		return nil, err
+	}
+	if t.err != nil {
+		// 不合法的条件可能在 not 或嵌套的分组中, 丢弃或替换单个条件都可能扩大查询结果, 因此整个查询不匹配任何文档
+		return elastic.NewBoolQuery().Filter(elastic.NewMatchNoneQuery()), nil
+	}
+	t.buildFacets()
 	querys := t.toQuery()
 	if len(querys) == 0 {
-		return elastic.NewBoolQuery()
+		return t.buildScore(elastic.NewBoolQuery()), nil
 	}
-	return querys[0].(*elastic.BoolQuery)
+	return t.buildScore(querys[0].(*elastic.BoolQuery)), nil
 }
 
+// reset 清除上一次解析的状态, 保留自定义回调, 解析计划和 facet 模式, 同一个对象可以多次解析
+func (t *StructToEsQuery) reset() {
+	*t = StructToEsQuery{root: t.root, custom: t.custom, plan: t.plan, facetMode: t.facetMode}
+}
+
+// GetSorters 获取排序, 游标分页时在最后添加 _id 排序
 func (t *StructToEsQuery) GetSorters() (res []elastic.Sorter) {
+	res = t.levelSorters()
+	if t.getCursor() != nil {
+		res = withTiebreaker(res)
+	}
+	return
+}
+
+// levelSorters 按level从小到大返回当前节点的排序
+func (t *StructToEsQuery) levelSorters() (res []elastic.Sorter) {
 	sort.Ints(t.levels)
 	for _, level := range t.levels {
 		res = append(res, t.sorters[level]...)
@@ -559,11 +1031,12 @@ func (t *StructToEsQuery) GetSorters() (res []elastic.Sorter) {
 }
 
 func (t *StructToEsQuery) Search(req *elastic.SearchService, form interface{}) (res *elastic.SearchHits, err error) {
-	req.Query(t.ToQuery(form)).SortBy(t.GetSorters()...)
-	if t.innerHits != nil {
-		t.innerHits.SetSource(req)
-	}
-	sr, err := req.Do(context.Background())
+	return t.SearchContext(context.Background(), req, form)
+}
+
+// SearchContext 同 Search, ctx 取消或超时时中止查询
+func (t *StructToEsQuery) SearchContext(ctx context.Context, req *elastic.SearchService, form interface{}) (res *elastic.SearchHits, err error) {
+	sr, err := t.do(ctx, req, form)
 	if err != nil || sr.Hits.TotalHits == 0 {
 		return
 	}
@@ -571,8 +1044,72 @@ func (t *StructToEsQuery) Search(req *elastic.SearchService, form interface{}) (
 	return
 }
 
+func (t *StructToEsQuery) do(ctx context.Context, req *elastic.SearchService, form interface{}) (*elastic.SearchResult, error) {
+	query, err := t.ToQueryE(form)
+	if err != nil {
+		return nil, err
+	}
+	if cursor := t.getCursor(); cursor != nil {
+		if _, err = DecodeCursor(*cursor); err != nil {
+			return nil, err
+		}
+	}
+	req.Query(query).SortBy(t.GetSorters()...)
+	if t.innerHits != nil {
+		t.innerHits.SetSource(req)
+	}
+	if hl := t.getHighlight(); hl != nil {
+		req.Highlight(hl)
+	}
+	for name, agg := range t.GetAggregations() {
+		req.Aggregation(name, agg)
+	}
+	if pf := t.GetPostFilter(); pf != nil {
+		req.PostFilter(pf)
+	}
+	return req.Do(ctx)
+}
+
+// ToSearchBody 解析结构体生成 SearchBody, tag错误时panic *TagError, 不希望panic时使用 ToSearchBodyE
+// 传入的值不合法时查询不匹配任何文档, 错误通过 Err 获取
 func (t *StructToEsQuery) ToSearchBody(form interface{}) *SearchBody {
-	res := NewSearchBody(t.ToQuery(form)).SetSorter(t.GetSorters()...)
+	res, err := t.buildSearchBody(form)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// ToSearchBodyE 同 ToSearchBody, tag错误时返回 *TagError, 传入的值不合法时返回 *InputError
+func (t *StructToEsQuery) ToSearchBodyE(form interface{}) (*SearchBody, error) {
+	res, err := t.buildSearchBody(form)
+	if err == nil {
+		err = t.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (t *StructToEsQuery) buildSearchBody(form interface{}) (*SearchBody, error) {
+	query, err := t.buildQuery(form)
+	if err != nil {
+		return nil, err
+	}
+	res := NewSearchBody(query).SetSorter(t.GetSorters()...)
+	res.Cursor = t.getCursor()
+	for name, agg := range t.GetAggregations() {
+		res.Aggregation(name, agg)
+	}
+	if pf := t.GetPostFilter(); pf != nil {
+		res.PostFilter = pf
+	}
+	if hl := t.getHighlight(); hl != nil {
+		res.Highlight = hl
+		res.highlightKeys = t.highlightKeys
+	}
+	res.innerPaths = t.innerPaths
 	if t.innerHits != nil {
 		res.SetPage(t.innerHits.GetPage()).SetSize(t.innerHits.GetSize())
 		if len(t.innerHits.GetInclude())+len(t.innerHits.GetExclude()) > 0 {
@@ -581,7 +1118,7 @@ func (t *StructToEsQuery) ToSearchBody(form interface{}) *SearchBody {
 				Exclude(t.innerHits.GetExclude()...)
 		}
 	}
-	return res
+	return res, nil
 }
 
 type SearchBody struct {
@@ -590,6 +1127,14 @@ type SearchBody struct {
 	Size   int
 	Source *elastic.FetchSourceContext
 	Sorter []elastic.Sorter
+	Aggs   map[string]elastic.Aggregation
+
+	PostFilter elastic.Query
+	Cursor     *string // 不为nil时使用游标分页, 忽略Page
+	Highlight  *elastic.Highlight
+
+	highlightKeys map[string]string // 高亮字段的es字段名与json名称的对应关系
+	innerPaths    map[string]string // 指定了名称的 inner_hits 与nested路径(join类型)的对应关系
 }
 
 func NewSearchBody(query *elastic.BoolQuery) *SearchBody {
@@ -648,15 +1193,59 @@ func (t *SearchBody) SetSorter(val ...elastic.Sorter) *SearchBody {
 	return t
 }
 
-func (t *SearchBody) Search(req *elastic.SearchService) (res *elastic.SearchHits, err error) {
-	req.Query(t.Query).SortBy(t.Sorter...)
-	if t.Source != nil {
-		req.FetchSourceContext(t.Source)
+// Aggregation 添加聚合, 名称相同时覆盖
+func (t *SearchBody) Aggregation(name string, agg elastic.Aggregation) *SearchBody {
+	if t.Aggs == nil {
+		t.Aggs = make(map[string]elastic.Aggregation)
 	}
-	sr, err := req.Do(context.Background())
+	t.Aggs[name] = agg
+	return t
+}
+
+func (t *SearchBody) Search(req *elastic.SearchService) (res *elastic.SearchHits, err error) {
+	return t.SearchContext(context.Background(), req)
+}
+
+// SearchContext 同 Search, ctx 取消或超时时中止查询
+func (t *SearchBody) SearchContext(ctx context.Context, req *elastic.SearchService) (res *elastic.SearchHits, err error) {
+	sr, err := t.do(ctx, req)
 	if err != nil || sr.Hits.TotalHits == 0 {
 		return
 	}
 	res = sr.Hits
 	return
 }
+
+func (t *SearchBody) do(ctx context.Context, req *elastic.SearchService) (*elastic.SearchResult, error) {
+	if t.Cursor != nil {
+		sort, err := DecodeCursor(*t.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		req.Query(t.Query).SortBy(withTiebreaker(t.Sorter)...)
+		if len(sort) > 0 {
+			req.SearchAfter(sort...)
+		}
+		if t.Size > 0 {
+			req.Size(t.Size)
+		}
+	} else {
+		req.Query(t.Query).SortBy(t.Sorter...)
+		if t.Page > 0 && t.Size > 0 {
+			req.From(t.Page*t.Size - t.Size).Size(t.Size)
+		}
+	}
+	if t.Source != nil {
+		req.FetchSourceContext(t.Source)
+	}
+	for name, agg := range t.Aggs {
+		req.Aggregation(name, agg)
+	}
+	if t.PostFilter != nil {
+		req.PostFilter(t.PostFilter)
+	}
+	if t.Highlight != nil {
+		req.Highlight(t.Highlight)
+	}
+	return req.Do(ctx)
+}
@@ -0,0 +1,100 @@
+package basics
+
+import (
+	"errors"
+	"fmt"
+	"github.com/olivere/elastic"
+	"strings"
+)
+
+// wildcardEscaper 转义wildcard的元字符
+var wildcardEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`)
+
+// regexpEscaper 转义lucene正则的保留字符
+var regexpEscaper = strings.NewReplacer(
+	`\`, `\\`, `.`, `\.`, `?`, `\?`, `+`, `\+`, `*`, `\*`, `|`, `\|`, `{`, `\{`, `}`, `\}`,
+	`[`, `\[`, `]`, `\]`, `(`, `\(`, `)`, `\)`, `"`, `\"`, `#`, `\#`, `@`, `\@`, `&`, `\&`,
+	`<`, `\<`, `>`, `\>`, `~`, `\~`,
+)
+
+// checkTermLevel 检查 prefix/wildcard/regexp 相关的tag
+func checkTermLevel(tags *esTags) error {
+	if tags.Pattern != "" && strings.Count(tags.Pattern, "%s") != 1 {
+		return errors.New("pattern 必须包含且只包含一个%s")
+	}
+	return nil
+}
+
+// termLevelQuery 生成 prefix/wildcard/regexp 查询
+// 默认转义用户输入中的元字符, wildcard 默认为 %s*, regexp 默认为 %s.*, 不使用开头的通配符以免扫描所有词项
+// 包含匹配需要指定pattern, 如 *%s*; 使用raw时不转义
+func (t *StructToEsQuery) termLevelQuery(name string, val interface{}) elastic.Query {
+	tags := t.tags
+	s := fmt.Sprint(val)
+	pattern := tags.Pattern
+	switch t.relational {
+	case "wildcard":
+		if !tags.Raw {
+			s = wildcardEscaper.Replace(s)
+			if pattern == "" {
+				pattern = "%s*"
+			}
+		}
+	case "regexp":
+		if !tags.Raw {
+			s = regexpEscaper.Replace(s)
+			if pattern == "" {
+				pattern = "%s.*"
+			}
+		}
+	}
+	if pattern != "" {
+		s = fmt.Sprintf(pattern, s)
+	}
+	var q elastic.Query
+	switch t.relational {
+	case "prefix":
+		q = elastic.NewPrefixQuery(name, s).Rewrite(tags.Rewrite)
+	case "wildcard":
+		q = elastic.NewWildcardQuery(name, s).Rewrite(tags.Rewrite)
+	default:
+		rq := elastic.NewRegexpQuery(name, s).Rewrite(tags.Rewrite)
+		if tags.Flags != "" {
+			rq.Flags(tags.Flags)
+		}
+		q = rq
+	}
+	if tags.CaseInsensitive {
+		q = &caseInsensitiveQuery{query: q, typ: t.relational, field: name}
+	}
+	return q
+}
+
+// caseInsensitiveQuery 为 prefix/wildcard/regexp 查询添加 case_insensitive, 需要es 7.10及以上版本
+type caseInsensitiveQuery struct {
+	query elastic.Query
+	typ   string
+	field string
+}
+
+func (q *caseInsensitiveQuery) Source() (interface{}, error) {
+	src, err := q.query.Source()
+	if err != nil {
+		return nil, err
+	}
+	source, ok := src.(map[string]interface{})
+	if !ok {
+		return src, nil
+	}
+	query, ok := source[q.typ].(map[string]interface{})
+	if !ok {
+		return src, nil
+	}
+	sub, ok := query[q.field].(map[string]interface{})
+	if !ok {
+		sub = map[string]interface{}{"value": query[q.field]}
+		query[q.field] = sub
+	}
+	sub["case_insensitive"] = true
+	return source, nil
+}